@@ -0,0 +1,318 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package pakt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	aclWildcard         = "*"
+	aclPrincipalPrefix  = "principal:"
+	aclRolePrefix       = "role:"
+	aclCommentCharacter = "#"
+)
+
+var (
+	// ErrPermissionDenied is returned to the caller if the access policy
+	// of the remote socket denies the function call.
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+//######################//
+//### Access Control ###//
+//######################//
+
+// Principal identifies an authenticated socket peer.
+type Principal struct {
+	// Name is the unique name of the principal, e.g. a user or service ID.
+	Name string
+
+	// Roles holds the roles assigned to the principal.
+	Roles []string
+}
+
+// HasRole returns a boolean indicating if the principal has the role assigned.
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessPolicy decides if a socket peer is permitted to call a function.
+type AccessPolicy interface {
+	// Permitted returns true if the peer of the socket may call the function.
+	// The principal is nil if the peer is not identified.
+	Permitted(s *Socket, p *Principal, funcID string) bool
+}
+
+// AccessPolicyFunc is an adapter to use ordinary functions as access policies.
+type AccessPolicyFunc func(s *Socket, p *Principal, funcID string) bool
+
+// Permitted calls f(s, p, funcID).
+func (f AccessPolicyFunc) Permitted(s *Socket, p *Principal, funcID string) bool {
+	return f(s, p, funcID)
+}
+
+// ACL defines an access control list which allows or denies function calls
+// per principal and role. Deny rules take precedence over allow rules.
+// If no rule matches, the default decision is used. The default denies.
+//
+// Function IDs of rules may be an exact ID, the wildcard "*" matching all
+// functions or a prefix ending with "*", e.g. "inventory.*".
+// Subjects are either "*" matching every peer (including unidentified peers),
+// "principal:<name>" or "role:<name>".
+//
+// All methods are thread-safe, so a loaded ACL may be replaced
+// at runtime while it is used by sockets.
+type ACL struct {
+	mutex        sync.RWMutex
+	defaultAllow bool
+	allowRules   []aclRule
+	denyRules    []aclRule
+}
+
+// NewACL creates a new empty access control list which denies all calls.
+func NewACL() *ACL {
+	return &ACL{}
+}
+
+// LoadACL creates a new access control list from the config read from r.
+// See ACL.Load for the format.
+func LoadACL(r io.Reader) (*ACL, error) {
+	a := NewACL()
+	err := a.Load(r)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// LoadACLFile creates a new access control list from the config file.
+// See ACL.Load for the format.
+func LoadACLFile(path string) (*ACL, error) {
+	a := NewACL()
+	err := a.LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// SetDefault sets the decision used if no rule matches.
+func (a *ACL) SetDefault(allow bool) {
+	a.mutex.Lock()
+	a.defaultAllow = allow
+	a.mutex.Unlock()
+}
+
+// Allow adds a rule allowing the subjects to call the function.
+func (a *ACL) Allow(funcID string, subjects ...string) {
+	a.mutex.Lock()
+	a.allowRules = append(a.allowRules, aclRule{FuncID: funcID, Subjects: subjects})
+	a.mutex.Unlock()
+}
+
+// Deny adds a rule denying the subjects to call the function.
+func (a *ACL) Deny(funcID string, subjects ...string) {
+	a.mutex.Lock()
+	a.denyRules = append(a.denyRules, aclRule{FuncID: funcID, Subjects: subjects})
+	a.mutex.Unlock()
+}
+
+// Permitted implements the AccessPolicy interface.
+func (a *ACL) Permitted(s *Socket, p *Principal, funcID string) bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	for _, r := range a.denyRules {
+		if r.matches(p, funcID) {
+			return false
+		}
+	}
+
+	for _, r := range a.allowRules {
+		if r.matches(p, funcID) {
+			return true
+		}
+	}
+
+	return a.defaultAllow
+}
+
+// LoadFile replaces all rules with the config read from the file.
+// See Load for the format.
+func (a *ACL) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return a.Load(f)
+}
+
+// Load replaces all rules with the config read from r.
+// The current rules are kept if the config is invalid.
+// Each line of the config holds one statement. Empty lines and
+// lines starting with '#' are ignored.
+//
+//	# Deny everything not explicitly allowed.
+//	default deny
+//
+//	allow  ping         *
+//	allow  inventory.*  role:staff
+//	deny   inventory.*  principal:intern
+//	allow  *            role:admin
+func (a *ACL) Load(r io.Reader) error {
+	var (
+		defaultAllow bool
+		allowRules   []aclRule
+		denyRules    []aclRule
+	)
+
+	lineNum := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNum++
+
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, aclCommentCharacter) {
+			continue
+		}
+
+		fields := strings.Fields(line)
+
+		switch fields[0] {
+		case "default":
+			if len(fields) != 2 {
+				return fmt.Errorf("acl: line %v: expected 'default allow|deny'", lineNum)
+			}
+
+			switch fields[1] {
+			case "allow":
+				defaultAllow = true
+			case "deny":
+				defaultAllow = false
+			default:
+				return fmt.Errorf("acl: line %v: invalid default decision: %v", lineNum, fields[1])
+			}
+
+		case "allow", "deny":
+			if len(fields) < 3 {
+				return fmt.Errorf("acl: line %v: expected '%v <function> <subject>...'", lineNum, fields[0])
+			}
+
+			rule := aclRule{
+				FuncID:   fields[1],
+				Subjects: fields[2:],
+			}
+
+			for _, sub := range rule.Subjects {
+				if !validACLSubject(sub) {
+					return fmt.Errorf("acl: line %v: invalid subject: %v", lineNum, sub)
+				}
+			}
+
+			if fields[0] == "allow" {
+				allowRules = append(allowRules, rule)
+			} else {
+				denyRules = append(denyRules, rule)
+			}
+
+		default:
+			return fmt.Errorf("acl: line %v: invalid statement: %v", lineNum, fields[0])
+		}
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return fmt.Errorf("acl: %v", err)
+	}
+
+	// Replace the current rules.
+	a.mutex.Lock()
+	a.defaultAllow = defaultAllow
+	a.allowRules = allowRules
+	a.denyRules = denyRules
+	a.mutex.Unlock()
+
+	return nil
+}
+
+//###############//
+//### Private ###//
+//###############//
+
+type aclRule struct {
+	FuncID   string
+	Subjects []string
+}
+
+func (r *aclRule) matches(p *Principal, funcID string) bool {
+	if !matchACLFuncID(r.FuncID, funcID) {
+		return false
+	}
+
+	for _, sub := range r.Subjects {
+		if matchACLSubject(sub, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchACLFuncID(pattern, funcID string) bool {
+	if strings.HasSuffix(pattern, aclWildcard) {
+		return strings.HasPrefix(funcID, strings.TrimSuffix(pattern, aclWildcard))
+	}
+	return pattern == funcID
+}
+
+func matchACLSubject(sub string, p *Principal) bool {
+	if sub == aclWildcard {
+		return true
+	} else if p == nil {
+		return false
+	}
+
+	if strings.HasPrefix(sub, aclPrincipalPrefix) {
+		return p.Name == strings.TrimPrefix(sub, aclPrincipalPrefix)
+	} else if strings.HasPrefix(sub, aclRolePrefix) {
+		return p.HasRole(strings.TrimPrefix(sub, aclRolePrefix))
+	}
+
+	return false
+}
+
+func validACLSubject(sub string) bool {
+	return sub == aclWildcard ||
+		(strings.HasPrefix(sub, aclPrincipalPrefix) && len(sub) > len(aclPrincipalPrefix)) ||
+		(strings.HasPrefix(sub, aclRolePrefix) && len(sub) > len(aclRolePrefix))
+}
@@ -0,0 +1,159 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package pakt_test
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/desertbit/pakt"
+	"github.com/desertbit/pakt/tcp"
+	"github.com/stretchr/testify/require"
+)
+
+const testACLConfig = `
+# Deny everything not explicitly allowed.
+default deny
+
+allow  ping         *
+allow  inventory.*  role:staff
+deny   inventory.*  principal:intern
+allow  *            role:admin
+`
+
+func TestACLLoad(t *testing.T) {
+	acl, err := pakt.LoadACL(strings.NewReader(testACLConfig))
+	require.NoError(t, err)
+
+	admin := &pakt.Principal{Name: "root", Roles: []string{"admin"}}
+	staff := &pakt.Principal{Name: "bob", Roles: []string{"staff"}}
+	intern := &pakt.Principal{Name: "intern", Roles: []string{"staff"}}
+
+	require.True(t, acl.Permitted(nil, nil, "ping"))
+	require.False(t, acl.Permitted(nil, nil, "inventory.list"))
+	require.True(t, acl.Permitted(nil, staff, "inventory.list"))
+	require.False(t, acl.Permitted(nil, staff, "users.delete"))
+	require.False(t, acl.Permitted(nil, intern, "inventory.list"))
+	require.True(t, acl.Permitted(nil, admin, "users.delete"))
+
+	// Replace the rules.
+	err = acl.Load(strings.NewReader("default allow\ndeny ping *"))
+	require.NoError(t, err)
+	require.False(t, acl.Permitted(nil, admin, "ping"))
+	require.True(t, acl.Permitted(nil, nil, "users.delete"))
+
+	// Invalid configs must not replace the current rules.
+	for _, c := range []string{
+		"default maybe",
+		"allow ping",
+		"allow ping user:bob",
+		"permit ping *",
+	} {
+		err = acl.Load(strings.NewReader(c))
+		require.Error(t, err, c)
+	}
+	require.False(t, acl.Permitted(nil, admin, "ping"))
+}
+
+func TestACLSocket(t *testing.T) {
+	var wg sync.WaitGroup
+
+	server, err := tcp.NewServer("127.0.0.1:45357")
+	require.NoError(t, err)
+	require.NotNil(t, server)
+
+	must := func(ok bool, args ...interface{}) {
+		if ok {
+			return
+		}
+
+		wg.Done()
+		t.Fatal(args...)
+	}
+
+	acl := pakt.NewACL()
+	acl.Allow("public", "*")
+	acl.Allow("denied", "*")
+	acl.Allow("private", "role:admin")
+
+	wg.Add(1)
+
+	server.OnNewSocket(func(s *pakt.Socket) {
+		s.SetAccessPolicy(acl)
+
+		s.RegisterFunc("public", func(c *pakt.Context) (interface{}, error) {
+			return nil, nil
+		})
+		s.RegisterFunc("private", func(c *pakt.Context) (interface{}, error) {
+			return nil, nil
+		})
+		s.RegisterFunc("denied", func(c *pakt.Context) (interface{}, error) {
+			return nil, errors.New("permission denied")
+		})
+		s.RegisterFunc("login", func(c *pakt.Context) (interface{}, error) {
+			c.Socket().SetPrincipal(&pakt.Principal{Name: "root", Roles: []string{"admin"}})
+			return nil, nil
+		})
+
+		s.Ready()
+	})
+
+	go func() {
+		server.Listen()
+	}()
+
+	go func() {
+		c, err := tcp.NewClient("127.0.0.1:45357")
+		must(err == nil, "client")
+
+		c.SetCallTimeout(2 * time.Second)
+		c.Ready()
+
+		_, err = c.Call("public")
+		must(err == nil, "public:", err)
+
+		_, err = c.Call("private")
+		must(err == pakt.ErrPermissionDenied, "private:", err)
+
+		// Errors of functions are not mapped by their message.
+		_, err = c.Call("denied")
+		must(err != nil && err != pakt.ErrPermissionDenied, "denied:", err)
+		must(err.Error() == "permission denied", "denied:", err)
+
+		_, err = c.Call("login")
+		must(err == pakt.ErrPermissionDenied, "login:", err)
+
+		acl.Allow("login", "*")
+
+		_, err = c.Call("login")
+		must(err == nil, "login:", err)
+
+		_, err = c.Call("private")
+		must(err == nil, "private:", err)
+
+		wg.Done()
+	}()
+
+	wg.Wait()
+
+	server.Close()
+}
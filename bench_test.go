@@ -0,0 +1,153 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package pakt_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/desertbit/pakt"
+	"github.com/desertbit/pakt/memory"
+)
+
+func newTCPSocketPair(b *testing.B) (*pakt.Socket, *pakt.Socket) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+
+	connChan := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			b.Error(err)
+		}
+		connChan <- conn
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return pakt.NewSocket(conn), pakt.NewSocket(<-connChan)
+}
+
+func benchmarkCall(b *testing.B, sa, sb *pakt.Socket, data []byte) {
+	defer sa.Close()
+
+	sb.RegisterFunc("echo", func(c *pakt.Context) (interface{}, error) {
+		var d []byte
+		err := c.Decode(&d)
+		return d, err
+	})
+
+	sa.Ready()
+	sb.Ready()
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		c, err := sa.Call("echo", data)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		var d []byte
+		err = c.Decode(&d)
+		if err != nil {
+			b.Fatal(err)
+		} else if len(d) != len(data) {
+			b.Fatal("invalid response")
+		}
+	}
+}
+
+func BenchmarkCallMemory(b *testing.B) {
+	sa, sb := memory.NewSocketPair()
+	benchmarkCall(b, sa, sb, []byte("hello"))
+}
+
+func BenchmarkCallMemoryLarge(b *testing.B) {
+	sa, sb := memory.NewSocketPair()
+	benchmarkCall(b, sa, sb, bytes.Repeat([]byte{1}, 64*1024))
+}
+
+func BenchmarkCallTCP(b *testing.B) {
+	sa, sb := newTCPSocketPair(b)
+	benchmarkCall(b, sa, sb, []byte("hello"))
+}
+
+func BenchmarkCallTCPLarge(b *testing.B) {
+	sa, sb := newTCPSocketPair(b)
+	benchmarkCall(b, sa, sb, bytes.Repeat([]byte{1}, 64*1024))
+}
+
+func BenchmarkCallTCPParallel(b *testing.B) {
+	sa, sb := newTCPSocketPair(b)
+	defer sa.Close()
+
+	sb.RegisterFunc("echo", func(c *pakt.Context) (interface{}, error) {
+		var d []byte
+		err := c.Decode(&d)
+		return d, err
+	})
+
+	sa.Ready()
+	sb.Ready()
+
+	data := []byte("hello")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, err := sa.Call("echo", data)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkCallEncrypted(b *testing.B) {
+	ka, err := pakt.NewKeyring(1, testKey1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	kb, err := pakt.NewKeyring(1, testKey1)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	sa, sb := memory.NewSocketPair()
+	if err = sa.SetEncryption(ka); err != nil {
+		b.Fatal(err)
+	}
+	if err = sb.SetEncryption(kb); err != nil {
+		b.Fatal(err)
+	}
+
+	benchmarkCall(b, sa, sb, bytes.Repeat([]byte{1}, 4*1024))
+}
@@ -0,0 +1,122 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package pakt
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+const (
+	minPooledBufferSize = 512
+	maxPooledBufferSize = 256 * 1024
+)
+
+// bufferPool holds reusable byte buffers used to frame messages.
+// Buffers passed to a Context are never pooled, because
+// the context data may be used after the handler returned.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, minPooledBufferSize)
+		return &b
+	},
+}
+
+// getBuffer returns a pooled buffer with the length n.
+// Release it with putBuffer.
+func getBuffer(n int) *[]byte {
+	bp := bufferPool.Get().(*[]byte)
+	if cap(*bp) < n {
+		*bp = make([]byte, n)
+	}
+	*bp = (*bp)[:n]
+	return bp
+}
+
+// putBuffer releases the buffer. The buffer must not be used afterwards.
+// Nil buffers are ignored.
+func putBuffer(bp *[]byte) {
+	// Don't keep large buffers in memory.
+	if bp == nil || cap(*bp) > maxPooledBufferSize {
+		return
+	}
+	bufferPool.Put(bp)
+}
+
+// supportsWritev returns a boolean indicating if the connection
+// writes net.Buffers with a single writev system call.
+func supportsWritev(conn net.Conn) bool {
+	switch conn.(type) {
+	case *net.TCPConn, *net.UnixConn, *net.IPConn:
+		return true
+	default:
+		return false
+	}
+}
+
+// bufferWriter appends the written bytes to a pooled buffer.
+// Writes fail with ErrMaxMsgSizeExceeded if the buffer would exceed the limit.
+type bufferWriter struct {
+	bp    *[]byte
+	limit int
+}
+
+func newBufferWriter(limit int) *bufferWriter {
+	bp := getBuffer(0)
+	return &bufferWriter{bp: bp, limit: limit}
+}
+
+func (w *bufferWriter) Write(p []byte) (int, error) {
+	if len(*w.bp)+len(p) > w.limit {
+		return 0, ErrMaxMsgSizeExceeded
+	}
+
+	*w.bp = append(*w.bp, p...)
+	return len(p), nil
+}
+
+// ReadFrom reads directly into the buffer until EOF.
+func (w *bufferWriter) ReadFrom(r io.Reader) (int64, error) {
+	var total int64
+	for {
+		b := *w.bp
+		if len(b) == cap(b) {
+			// Grow the buffer.
+			b = append(b, 0)[:len(b)]
+		}
+
+		// Read one byte beyond the limit to detect exceeded limits.
+		end := cap(b)
+		if end > w.limit+1 {
+			end = w.limit + 1
+		}
+
+		n, err := r.Read(b[len(b):end])
+		*w.bp = b[:len(b)+n]
+		total += int64(n)
+
+		if len(*w.bp) > w.limit {
+			return total, ErrMaxMsgSizeExceeded
+		} else if err == io.EOF {
+			return total, nil
+		} else if err != nil {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,79 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package pakt
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(b []byte) (int, error)        { return len(b), nil }
+func (discardConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func TestBufferPool(t *testing.T) {
+	bp := getBuffer(10)
+	require.Len(t, *bp, 10)
+	putBuffer(bp)
+
+	bp = getBuffer(maxPooledBufferSize + 1)
+	require.Len(t, *bp, maxPooledBufferSize+1)
+	putBuffer(bp)
+	putBuffer(nil)
+}
+
+func TestWriteFrameAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops items with the race detector")
+	}
+
+	s := NewSocket(discardConn{})
+	header := make([]byte, 32)
+	payload := make([]byte, 1024)
+
+	// Warm up the buffer pool.
+	require.NoError(t, s.writeFrame(ProtocolVersion, typeCall, header, payload))
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = s.writeFrame(ProtocolVersion, typeCall, header, payload)
+	})
+	require.Zero(t, allocs)
+}
+
+func BenchmarkWriteFrame(b *testing.B) {
+	s := NewSocket(discardConn{})
+	header := make([]byte, 32)
+	payload := make([]byte, 1024)
+
+	b.SetBytes(int64(len(header) + len(payload)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		err := s.writeFrame(ProtocolVersion, typeCall, header, payload)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -15,72 +15,52 @@
  *  You should have received a copy of the GNU General Public License
  *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
  */
-
 package pakt
 
 import "sync"
 
-const (
-	chainIDLength = 10
-)
-
 //##################//
 //### Chain Type ###//
 //##################//
 
 type chainChan chan interface{}
 
+// chain correlates call returns with the waiting calls.
+// The keys are unique per socket and increase monotonically.
 type chain struct {
-	chanMap        map[string]chainChan
+	chanMap        map[uint64]chainChan
 	chainChanMutex sync.Mutex
+	nextID         uint64
 }
 
 func newChain() *chain {
 	return &chain{
-		chanMap: make(map[string]chainChan),
+		chanMap: make(map[uint64]chainChan),
 	}
 }
 
-func (c *chain) New() (id string, cc chainChan, err error) {
+func (c *chain) New() (id uint64, cc chainChan) {
 	// Create a new channel.
 	cc = make(chainChan)
 
-	// Create a new ID and ensure it is unqiue.
-	var added bool
-	for {
-		id, err = randomString(chainIDLength)
-		if err != nil {
-			return
-		}
-
-		added = func() bool {
-			c.chainChanMutex.Lock()
-			defer c.chainChanMutex.Unlock()
-
-			if _, ok := c.chanMap[id]; ok {
-				return false
-			}
-
-			c.chanMap[id] = cc
-			return true
-		}()
-		if added {
-			break
-		}
-	}
+	c.chainChanMutex.Lock()
+	c.nextID++
+	id = c.nextID
+	c.chanMap[id] = cc
+	c.chainChanMutex.Unlock()
 
 	return
 }
 
 // Returns nil if not found.
-func (c *chain) Get(id string) (cc chainChan) {
+func (c *chain) Get(id uint64) (cc chainChan) {
 	c.chainChanMutex.Lock()
 	cc = c.chanMap[id]
 	c.chainChanMutex.Unlock()
 	return
 }
 
-func (c *chain) Delete(id string) {
+func (c *chain) Delete(id uint64) {
 	c.chainChanMutex.Lock()
 	delete(c.chanMap, id)
 	c.chainChanMutex.Unlock()
@@ -0,0 +1,81 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cbor
+
+import (
+	"io"
+
+	"github.com/desertbit/pakt/codec"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Codec that encodes to and decodes from CBOR (RFC 8949).
+// Values are encoded deterministically with the core deterministic encoding
+// requirements, so equal values always result in equal bytes and
+// the encoded messages can be signed.
+// Struct fields are named by the "cbor" struct tag. The "json" struct tag
+// is used as fallback. The "toarray" option of the special "_" field encodes
+// a struct as array. Time values are encoded as RFC 3339 strings
+// with nanosecond precision.
+var Codec = cborCodec{
+	encMode: mustEncMode(),
+}
+
+type cborCodec struct {
+	encMode cbor.EncMode
+}
+
+// Encode the value to a deterministic CBOR byte slice.
+func (c cborCodec) Encode(v interface{}) ([]byte, error) {
+	return c.encMode.Marshal(v)
+}
+
+// Decode the byte slice to a value.
+func (c cborCodec) Decode(b []byte, v interface{}) error {
+	return cbor.Unmarshal(b, v)
+}
+
+// EncodeTo encodes the value deterministically to the writer.
+func (c cborCodec) EncodeTo(w io.Writer, v interface{}) error {
+	return c.encMode.NewEncoder(w).Encode(v)
+}
+
+// DecodeFrom decodes the value from the reader.
+// Returns codec.ErrTrailingData if the reader contains more data.
+func (c cborCodec) DecodeFrom(r io.Reader, v interface{}) error {
+	dec := cbor.NewDecoder(r)
+	err := dec.Decode(v)
+	if err != nil {
+		return err
+	}
+	return codec.CheckEOF(io.MultiReader(dec.Buffered(), r))
+}
+
+func mustEncMode() cbor.EncMode {
+	// The core deterministic options encode time values as integer
+	// Unix seconds, which drops the fraction. Keep the nanoseconds.
+	opts := cbor.CoreDetEncOptions()
+	opts.Time = cbor.TimeRFC3339Nano
+
+	m, err := opts.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
@@ -0,0 +1,112 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cbor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/desertbit/pakt/codec/codectest"
+	"github.com/desertbit/pakt/codec/internal"
+	"github.com/stretchr/testify/require"
+)
+
+type taggedStruct struct {
+	Name  string            `cbor:"n"`
+	Count int               `cbor:"c,omitempty"`
+	Tags  map[string]string `json:"t"`
+	Skip  string            `cbor:"-"`
+}
+
+type arrayStruct struct {
+	_    struct{} `cbor:",toarray"`
+	Name string
+	ID   uint64
+}
+
+func TestCBOR(t *testing.T) {
+	internal.RoundtripTester(t, Codec)
+	internal.RoundtripTester(t, Codec,
+		&taggedStruct{Name: "test", Count: 2, Tags: map[string]string{"a": "1"}},
+		&taggedStruct{},
+	)
+	internal.RoundtripTester(t, Codec, &arrayStruct{Name: "test", ID: 1}, &arrayStruct{})
+}
+
+func TestCBORStructTags(t *testing.T) {
+	b, err := Codec.Encode(&taggedStruct{Name: "x", Skip: "skip"})
+	require.NoError(t, err)
+
+	// Map with the keys "n" and "t". The nil map is encoded as null.
+	require.Equal(t, []byte{0xa2, 0x61, 'n', 0x61, 'x', 0x61, 't', 0xf6}, b)
+
+	b, err = Codec.Encode(&arrayStruct{Name: "x", ID: 1})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x82, 0x61, 'x', 0x01}, b)
+}
+
+func TestCBORDeterministic(t *testing.T) {
+	m := map[string]int{
+		"ccc": 3,
+		"a":   1,
+		"bb":  2,
+		"d":   4,
+	}
+
+	first, err := Codec.Encode(m)
+	require.NoError(t, err)
+
+	// Keys are sorted by their encoded bytes.
+	require.Equal(t, []byte{
+		0xa4,
+		0x61, 'a', 0x01,
+		0x61, 'd', 0x04,
+		0x62, 'b', 'b', 0x02,
+		0x63, 'c', 'c', 'c', 0x03,
+	}, first)
+
+	for i := 0; i < 20; i++ {
+		b, err := Codec.Encode(m)
+		require.NoError(t, err)
+		require.Equal(t, first, b)
+	}
+
+	// Integers use the shortest encoding.
+	b, err := Codec.Encode(uint64(1))
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x01}, b)
+}
+
+func TestCBORTime(t *testing.T) {
+	type times struct {
+		Time time.Time
+	}
+
+	v := &times{Time: time.Date(2017, 12, 17, 10, 30, 15, 123456789, time.UTC)}
+	b, err := Codec.Encode(v)
+	require.NoError(t, err)
+
+	var to times
+	require.NoError(t, Codec.Decode(b, &to))
+	require.True(t, v.Time.Equal(to.Time), "expected %v, got %v", v.Time, to.Time)
+}
+
+func TestConformance(t *testing.T) {
+	codectest.Run(t, Codec)
+}
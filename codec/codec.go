@@ -20,8 +20,41 @@
 // to encode and decode the PAKT messages.
 package codec
 
+import (
+	"errors"
+	"io"
+)
+
+// ErrTrailingData is returned by StreamCodec.DecodeFrom if the reader
+// contains more data after the decoded value.
+var ErrTrailingData = errors.New("trailing data after decoded value")
+
 // Codec represents a codec used to encode and decode entities.
 type Codec interface {
 	Encode(v interface{}) ([]byte, error)
 	Decode(b []byte, v interface{}) error
 }
+
+// StreamCodec is an optional extension of a Codec, which encodes directly
+// to a writer and decodes directly from a reader without intermediate byte slices.
+// Sockets use the stream methods if the codec implements them.
+// DecodeFrom decodes a single value and consumes the complete reader.
+// It must return ErrTrailingData if the reader contains more data.
+type StreamCodec interface {
+	Codec
+	EncodeTo(w io.Writer, v interface{}) error
+	DecodeFrom(r io.Reader, v interface{}) error
+}
+
+// CheckEOF returns ErrTrailingData if the reader is not drained.
+// Stream codecs call it after decoding a value from the reader.
+func CheckEOF(r io.Reader) error {
+	var b [1]byte
+	_, err := io.ReadAtLeast(r, b[:], 1)
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return ErrTrailingData
+}
@@ -0,0 +1,456 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package codectest provides a conformance test suite for codec.Codec implementations.
+// Run it from a test of the codec package:
+//
+//	func TestCodec(t *testing.T) {
+//		codectest.Run(t, mycodec.Codec)
+//	}
+//
+// Codecs which only encode their own types pass fixtures to RunFixtures instead.
+package codectest
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/desertbit/pakt/codec"
+)
+
+const (
+	largePayloadSize = 4 * 1024 * 1024
+	concurrentRuns   = 16
+	concurrentLoops  = 100
+)
+
+// Item is a struct used by the conformance tests.
+type Item struct {
+	Name  string
+	Count int
+	Tags  []string
+}
+
+// Nested is a struct with nested values used by the conformance tests.
+type Nested struct {
+	ID       uint64
+	Item     Item
+	Ptr      *Item
+	Items    []Item
+	ItemsMap map[string]Item
+	Scores   map[string]float64
+}
+
+// Nils is a struct with nil values used by the conformance tests.
+type Nils struct {
+	Name  string
+	Ptr   *Item
+	Slice []int
+	Map   map[string]int
+	Bytes []byte
+}
+
+// Times is a struct with time values used by the conformance tests.
+type Times struct {
+	Time time.Time
+}
+
+// Large is a struct with a large payload used by the conformance tests.
+type Large struct {
+	Data    []byte
+	Strings []string
+}
+
+// Run runs the conformance tests for the codec as subtests. Codecs must
+// encode and decode nil values, nested structs, maps, slices, time values and
+// large payloads, return errors when decoding into wrong types and be safe
+// for concurrent use. Stream codecs are also tested with their stream methods.
+func Run(t *testing.T, c codec.Codec) {
+	t.Run("Nil", func(t *testing.T) { testNil(t, c) })
+	t.Run("Nested", func(t *testing.T) { testNested(t, c) })
+	t.Run("Maps", func(t *testing.T) { testMaps(t, c) })
+	t.Run("Slices", func(t *testing.T) { testSlices(t, c) })
+	t.Run("Time", func(t *testing.T) { testTime(t, c) })
+	t.Run("Large", func(t *testing.T) { testLarge(t, c) })
+	t.Run("WrongType", func(t *testing.T) { testWrongType(t, c) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrent(t, c) })
+}
+
+// Fixture is a value used by RunFixtures.
+type Fixture struct {
+	// Name of the fixture used for the subtest.
+	Name string
+
+	// Value is a pointer, which is decoded into a new value of its type.
+	Value interface{}
+
+	// Equal compares the values. Defaults to reflect.DeepEqual.
+	Equal func(a, b interface{}) bool
+}
+
+// RunFixtures runs the conformance tests with the fixtures instead of the
+// plain structs used by Run. Use it for codecs which only encode their own
+// types, like protobuf messages. The fixtures should cover nil fields, nested
+// values, maps, slices, time values and large payloads. Each fixture is tested
+// with a roundtrip, also concurrently. Codecs must fail to encode nil or encode
+// it to data decoding to the zero value and return errors when decoding into
+// wrong types.
+func RunFixtures(t *testing.T, c codec.Codec, fixtures ...Fixture) {
+	if len(fixtures) == 0 {
+		t.Fatal("no fixtures")
+	}
+
+	t.Run("Roundtrip", func(t *testing.T) {
+		for _, f := range fixtures {
+			f := f
+			t.Run(f.Name, func(t *testing.T) {
+				RoundtripFunc(t, c, f.equal(), f.Value, f.new())
+			})
+		}
+	})
+	t.Run("Nil", func(t *testing.T) { testNilFixture(t, c, fixtures[0]) })
+	t.Run("WrongType", func(t *testing.T) { testWrongTypeFixture(t, c, fixtures[0]) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrentFixtures(t, c, fixtures) })
+}
+
+// Roundtrip encodes the value, decodes it to the pointer and fails the test
+// if the decoded value does not deeply equal the value.
+// Stream codecs are also tested with their stream methods.
+func Roundtrip(t *testing.T, c codec.Codec, val, to interface{}) {
+	t.Helper()
+	RoundtripFunc(t, c, reflect.DeepEqual, val, to)
+}
+
+// RoundtripFunc is like Roundtrip, but compares the values with the equal function.
+func RoundtripFunc(t *testing.T, c codec.Codec, equal func(a, b interface{}) bool, val, to interface{}) {
+	t.Helper()
+
+	err := roundtrip(c, equal, val, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+//###############//
+//### Private ###//
+//###############//
+
+func (f Fixture) equal() func(a, b interface{}) bool {
+	if f.Equal == nil {
+		return reflect.DeepEqual
+	}
+	return f.Equal
+}
+
+// new returns a pointer to a new zero value of the fixture type.
+func (f Fixture) new() interface{} {
+	return reflect.New(reflect.TypeOf(f.Value).Elem()).Interface()
+}
+
+func roundtrip(c codec.Codec, equal func(a, b interface{}) bool, val, to interface{}) error {
+	// Create a new value for the stream test before the pointer is modified.
+	toStream := reflect.New(reflect.TypeOf(to).Elem()).Interface()
+
+	b, err := c.Encode(val)
+	if err != nil {
+		return fmt.Errorf("encode: %v", err)
+	}
+	err = c.Decode(b, to)
+	if err != nil {
+		return fmt.Errorf("decode: %v", err)
+	}
+	if !equal(val, to) {
+		return fmt.Errorf("roundtrip mismatch, expected\n%#v\ngot\n%#v", val, to)
+	}
+
+	sc, ok := c.(codec.StreamCodec)
+	if !ok {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	err = sc.EncodeTo(&buf, val)
+	if err != nil {
+		return fmt.Errorf("encode to: %v", err)
+	}
+	err = sc.DecodeFrom(&buf, toStream)
+	if err != nil {
+		return fmt.Errorf("decode from: %v", err)
+	}
+	if !equal(val, toStream) {
+		return fmt.Errorf("stream roundtrip mismatch, expected\n%#v\ngot\n%#v", val, toStream)
+	}
+
+	// Trailing data must be rejected.
+	err = sc.EncodeTo(&buf, val)
+	if err != nil {
+		return fmt.Errorf("encode to: %v", err)
+	}
+	buf.WriteByte(0)
+	err = sc.DecodeFrom(&buf, reflect.New(reflect.TypeOf(to).Elem()).Interface())
+	if err != codec.ErrTrailingData {
+		return fmt.Errorf("decode from with trailing data: expected %v, got %v", codec.ErrTrailingData, err)
+	}
+
+	return nil
+}
+
+func testNil(t *testing.T, c codec.Codec) {
+	// Nil fields stay nil.
+	Roundtrip(t, c, &Nils{Name: "nil"}, &Nils{})
+
+	// Nil pointers, slices and maps within nested structs.
+	Roundtrip(t, c, &Nested{ID: 1, Ptr: nil, Items: nil, ItemsMap: nil}, &Nested{})
+
+	// Nil pointers within slices and maps either fail to encode or stay nil.
+	for _, v := range []interface{}{
+		&[]*Item{{Name: "first"}, nil},
+		&map[string]*Item{"item": {Name: "item"}, "nil": nil},
+	} {
+		b, err := c.Encode(v)
+		if err != nil {
+			continue
+		}
+
+		to := reflect.New(reflect.TypeOf(v).Elem()).Interface()
+		err = c.Decode(b, to)
+		if err != nil {
+			t.Errorf("decoding encoded %#v: %v", v, err)
+		} else if !reflect.DeepEqual(v, to) {
+			t.Errorf("roundtrip mismatch, expected\n%#v\ngot\n%#v", v, to)
+		}
+	}
+
+	// Nil values either fail to encode or decode to the zero value.
+	for _, v := range []interface{}{nil, (*Item)(nil)} {
+		b, err := c.Encode(v)
+		if err != nil {
+			continue
+		}
+
+		var item Item
+		err = c.Decode(b, &item)
+		if err != nil {
+			t.Errorf("decoding encoded %#v: %v", v, err)
+		} else if !reflect.DeepEqual(item, Item{}) {
+			t.Errorf("decoding encoded %#v: expected the zero value, got %#v", v, item)
+		}
+	}
+}
+
+func testNested(t *testing.T, c codec.Codec) {
+	val := &Nested{
+		ID:   42,
+		Item: Item{Name: "item", Count: 1, Tags: []string{"a", "b"}},
+		Ptr:  &Item{Name: "ptr", Count: 2},
+		Items: []Item{
+			{Name: "first", Count: 3, Tags: []string{"c"}},
+			{Name: "second", Count: 4},
+		},
+		ItemsMap: map[string]Item{
+			"x": {Name: "x", Count: 5},
+			"y": {Name: "y", Count: 6, Tags: []string{"d", "e", "f"}},
+		},
+		Scores: map[string]float64{"pi": 3.14159, "e": 2.71828},
+	}
+	Roundtrip(t, c, val, &Nested{})
+}
+
+func testMaps(t *testing.T, c codec.Codec) {
+	m := map[string]int{"a": 1, "b": 2, "c": -3}
+	var mTo map[string]int
+	Roundtrip(t, c, &m, &mTo)
+
+	ms := map[string][]string{"a": {"1", "2"}, "b": {"3"}}
+	var msTo map[string][]string
+	Roundtrip(t, c, &ms, &msTo)
+
+	mm := map[string]map[string]string{"outer": {"inner": "value"}}
+	var mmTo map[string]map[string]string
+	Roundtrip(t, c, &mm, &mmTo)
+}
+
+func testSlices(t *testing.T, c codec.Codec) {
+	ints := []int{1, -2, 3, 1 << 40}
+	var intsTo []int
+	Roundtrip(t, c, &ints, &intsTo)
+
+	strs := []string{"a", "", "unicode ✓"}
+	var strsTo []string
+	Roundtrip(t, c, &strs, &strsTo)
+
+	bs := []byte{0, 1, 2, 255}
+	var bsTo []byte
+	Roundtrip(t, c, &bs, &bsTo)
+
+	nested := [][]int{{1, 2}, {3}}
+	var nestedTo [][]int
+	Roundtrip(t, c, &nested, &nestedTo)
+}
+
+func testTime(t *testing.T, c codec.Codec) {
+	// Time values must represent the same instant.
+	// The location and monotonic clock reading may be lost.
+	equal := func(a, b interface{}) bool {
+		return a.(*Times).Time.Equal(b.(*Times).Time)
+	}
+
+	RoundtripFunc(t, c, equal, &Times{Time: time.Date(2017, 12, 17, 10, 30, 15, 0, time.UTC)}, &Times{})
+	RoundtripFunc(t, c, equal, &Times{Time: time.Date(2017, 12, 17, 10, 30, 15, 123456789, time.UTC)}, &Times{})
+	RoundtripFunc(t, c, equal, &Times{Time: time.Date(1969, 7, 20, 20, 17, 40, 0, time.FixedZone("test", 3600))}, &Times{})
+}
+
+func testLarge(t *testing.T, c codec.Codec) {
+	data := make([]byte, largePayloadSize)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	strs := make([]string, 10000)
+	for i := range strs {
+		strs[i] = strings.Repeat("s", i%100)
+	}
+
+	Roundtrip(t, c, &Large{Data: data, Strings: strs}, &Large{})
+}
+
+func testWrongType(t *testing.T, c codec.Codec) {
+	b, err := c.Encode(&Item{Name: "item", Count: 1})
+	if err != nil {
+		t.Fatal("encode:", err)
+	}
+
+	var i int
+	if err = c.Decode(b, &i); err == nil {
+		t.Errorf("decoding a struct into %T: expected an error", &i)
+	}
+
+	var s []string
+	if err = c.Decode(b, &s); err == nil {
+		t.Errorf("decoding a struct into %T: expected an error", &s)
+	}
+
+	b, err = c.Encode("text")
+	if err != nil {
+		t.Fatal("encode:", err)
+	}
+
+	var item Item
+	if err = c.Decode(b, &item); err == nil {
+		t.Errorf("decoding a string into %T: expected an error", &item)
+	}
+
+	// Empty data is invalid.
+	if err = c.Decode(nil, &item); err == nil {
+		t.Error("decoding empty data: expected an error")
+	}
+}
+
+func testConcurrent(t *testing.T, c codec.Codec) {
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrentRuns)
+
+	for r := 0; r < concurrentRuns; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+
+			for i := 0; i < concurrentLoops; i++ {
+				val := &Item{
+					Name:  fmt.Sprintf("routine %v", r),
+					Count: i,
+					Tags:  []string{fmt.Sprint(r, i)},
+				}
+
+				err := roundtrip(c, reflect.DeepEqual, val, &Item{})
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(r)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func testNilFixture(t *testing.T, c codec.Codec, f Fixture) {
+	b, err := c.Encode(nil)
+	if err != nil {
+		return
+	}
+
+	v := f.new()
+	err = c.Decode(b, v)
+	if err != nil {
+		t.Errorf("decoding encoded nil: %v", err)
+	} else if !f.equal()(v, f.new()) {
+		t.Errorf("decoding encoded nil: expected the zero value, got %#v", v)
+	}
+}
+
+func testWrongTypeFixture(t *testing.T, c codec.Codec, f Fixture) {
+	b, err := c.Encode(f.Value)
+	if err != nil {
+		t.Fatal("encode:", err)
+	}
+
+	var i int
+	if err = c.Decode(b, &i); err == nil {
+		t.Errorf("decoding %T into %T: expected an error", f.Value, &i)
+	}
+}
+
+func testConcurrentFixtures(t *testing.T, c codec.Codec, fixtures []Fixture) {
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrentRuns)
+
+	for r := 0; r < concurrentRuns; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+
+			for i := 0; i < concurrentLoops; i++ {
+				f := fixtures[(r+i)%len(fixtures)]
+
+				err := roundtrip(c, f.equal(), f.Value, f.new())
+				if err != nil {
+					errs <- fmt.Errorf("%v: %v", f.Name, err)
+					return
+				}
+			}
+		}(r)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
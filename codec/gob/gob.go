@@ -0,0 +1,94 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package gob
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"reflect"
+
+	"github.com/desertbit/pakt/codec"
+)
+
+// Codec that encodes to and decodes from gob.
+// Gob is stream-oriented and sends the type descriptors only once per stream.
+// Each value is encoded with a new encoder, so every message includes its
+// type descriptors and decodes independently of previous messages.
+// Concrete types sent as interface values must be registered with Register.
+// Only use this codec if both peers are Go applications.
+var Codec = gobCodec{}
+
+// Register records a type, identified by a value for the type, so it can
+// be sent as interface value. It must be called by both peers before the
+// type is encoded or decoded. See encoding/gob.Register.
+func Register(value interface{}) {
+	gob.Register(value)
+}
+
+// RegisterName is like Register but uses the provided name
+// rather than the type's default.
+func RegisterName(name string, value interface{}) {
+	gob.RegisterName(name, value)
+}
+
+type gobCodec struct{}
+
+// Encode the value to a gob byte slice including the type descriptors.
+func (c gobCodec) Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := c.EncodeTo(&buf, v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode the byte slice to a value.
+// Returns codec.ErrTrailingData if the byte slice contains more data.
+func (c gobCodec) Decode(b []byte, v interface{}) error {
+	return c.DecodeFrom(bytes.NewReader(b), v)
+}
+
+// EncodeTo encodes the value including the type descriptors to the writer.
+func (c gobCodec) EncodeTo(w io.Writer, v interface{}) error {
+	// The gob encoder panics on nil pointers.
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return errors.New("gob: cannot encode nil pointer value")
+	}
+	return gob.NewEncoder(w).Encode(v)
+}
+
+// DecodeFrom decodes the value from the reader.
+// Returns codec.ErrTrailingData if the reader contains more data.
+func (c gobCodec) DecodeFrom(r io.Reader, v interface{}) error {
+	// The decoder buffers readers without ReadByte internally,
+	// which would hide trailing data.
+	if _, ok := r.(io.ByteReader); !ok {
+		r = bufio.NewReader(r)
+	}
+
+	err := gob.NewDecoder(r).Decode(v)
+	if err != nil {
+		return err
+	}
+	return codec.CheckEOF(r)
+}
@@ -0,0 +1,94 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package gob
+
+import (
+	"testing"
+
+	"github.com/desertbit/pakt/codec/codectest"
+	"github.com/desertbit/pakt/codec/internal"
+	"github.com/stretchr/testify/require"
+)
+
+type shape interface {
+	Area() float64
+}
+
+type square struct {
+	Side float64
+}
+
+func (s *square) Area() float64 {
+	return s.Side * s.Side
+}
+
+type message struct {
+	Name  string
+	Shape shape
+	Value interface{}
+}
+
+func init() {
+	Register(&square{})
+}
+
+func TestGob(t *testing.T) {
+	internal.RoundtripTester(t, Codec)
+	internal.RoundtripTester(t, Codec,
+		&message{Name: "test", Shape: &square{Side: 2}, Value: int64(3)},
+		&message{},
+	)
+}
+
+func TestGobIndependentFrames(t *testing.T) {
+	first, err := Codec.Encode(&message{Name: "first", Shape: &square{Side: 1}})
+	require.NoError(t, err)
+
+	second, err := Codec.Encode(&message{Name: "second", Shape: &square{Side: 2}})
+	require.NoError(t, err)
+
+	// Decode in reverse order. Each frame holds its own type descriptors.
+	var m message
+	require.NoError(t, Codec.Decode(second, &m))
+	require.Equal(t, "second", m.Name)
+	require.Equal(t, 4.0, m.Shape.Area())
+
+	m = message{}
+	require.NoError(t, Codec.Decode(first, &m))
+	require.Equal(t, "first", m.Name)
+	require.Equal(t, 1.0, m.Shape.Area())
+
+	// Frames can be decoded multiple times.
+	m = message{}
+	require.NoError(t, Codec.Decode(second, &m))
+	require.Equal(t, "second", m.Name)
+}
+
+func TestGobUnregistered(t *testing.T) {
+	type unregistered struct {
+		Side float64
+	}
+
+	_, err := Codec.Encode(&message{Value: unregistered{}})
+	require.Error(t, err)
+}
+
+func TestConformance(t *testing.T) {
+	codectest.Run(t, Codec)
+}
@@ -24,6 +24,7 @@ import (
 	"testing"
 
 	"github.com/desertbit/pakt/codec"
+	"github.com/desertbit/pakt/codec/codectest"
 )
 
 type testStruct struct {
@@ -32,6 +33,12 @@ type testStruct struct {
 
 // RoundtripTester is a test helper to test a Codec
 func RoundtripTester(t *testing.T, c codec.Codec, vals ...interface{}) {
+	RoundtripTesterFunc(t, c, reflect.DeepEqual, vals...)
+}
+
+// RoundtripTesterFunc is a test helper to test a Codec.
+// The values are compared with the equal function.
+func RoundtripTesterFunc(t *testing.T, c codec.Codec, equal func(a, b interface{}) bool, vals ...interface{}) {
 	var val, to interface{}
 	if len(vals) > 0 {
 		if len(vals) != 2 {
@@ -44,17 +51,7 @@ func RoundtripTester(t *testing.T, c codec.Codec, vals ...interface{}) {
 		to = &testStruct{}
 	}
 
-	encoded, err := c.Encode(val)
-	if err != nil {
-		t.Fatal("Encode error:", err)
-	}
-	err = c.Decode(encoded, to)
-	if err != nil {
-		t.Fatal("Decode error:", err)
-	}
-	if !reflect.DeepEqual(val, to) {
-		t.Fatalf("Roundtrip codec mismatch, expected\n%#v\ngot\n%#v", val, to)
-	}
+	codectest.RoundtripFunc(t, c, equal, val, to)
 }
 
 func init() {
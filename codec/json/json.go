@@ -18,7 +18,12 @@
 
 package json
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/desertbit/pakt/codec"
+)
 
 // Codec that encodes to and decodes from JSON.
 var Codec = jsonCodec{}
@@ -32,3 +37,21 @@ func (j jsonCodec) Encode(v interface{}) ([]byte, error) {
 func (j jsonCodec) Decode(b []byte, v interface{}) error {
 	return json.Unmarshal(b, v)
 }
+
+func (j jsonCodec) EncodeTo(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (j jsonCodec) DecodeFrom(r io.Reader, v interface{}) error {
+	dec := json.NewDecoder(r)
+	err := dec.Decode(v)
+	if err != nil {
+		return err
+	}
+
+	// Only whitespace may follow the value.
+	if _, err = dec.Token(); err != io.EOF {
+		return codec.ErrTrailingData
+	}
+	return nil
+}
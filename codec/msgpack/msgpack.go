@@ -19,6 +19,10 @@
 package msgpack
 
 import (
+	"bufio"
+	"io"
+
+	"github.com/desertbit/pakt/codec"
 	"github.com/tinylib/msgp/msgp"
 	msgpack "gopkg.in/vmihailenco/msgpack.v2"
 )
@@ -48,3 +52,34 @@ func (c msgpackCodec) Decode(b []byte, v interface{}) error {
 
 	return msgpack.Unmarshal(b, v)
 }
+
+// EncodeTo encodes the value to the writer.
+// It uses the faster msgp.Encodable if implemented.
+func (c msgpackCodec) EncodeTo(w io.Writer, v interface{}) error {
+	if e, ok := v.(msgp.Encodable); ok {
+		return msgp.Encode(w, e)
+	}
+
+	return msgpack.NewEncoder(w).Encode(v)
+}
+
+// DecodeFrom decodes the value from the reader.
+// It uses the faster msgp.Decodable if implemented.
+// Returns codec.ErrTrailingData if the reader contains more data.
+func (c msgpackCodec) DecodeFrom(r io.Reader, v interface{}) error {
+	if d, ok := v.(msgp.Decodable); ok {
+		m := msgp.NewReader(r)
+		err := d.DecodeMsg(m)
+		if err != nil {
+			return err
+		}
+		return codec.CheckEOF(m)
+	}
+
+	br := bufio.NewReader(r)
+	err := msgpack.NewDecoder(br).Decode(v)
+	if err != nil {
+		return err
+	}
+	return codec.CheckEOF(br)
+}
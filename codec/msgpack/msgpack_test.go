@@ -21,9 +21,14 @@ package msgpack
 import (
 	"testing"
 
+	"github.com/desertbit/pakt/codec/codectest"
 	"github.com/desertbit/pakt/codec/internal"
 )
 
 func TestMSGPack(t *testing.T) {
 	internal.RoundtripTester(t, Codec)
 }
+
+func TestConformance(t *testing.T) {
+	codectest.Run(t, Codec)
+}
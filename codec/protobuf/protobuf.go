@@ -0,0 +1,51 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package protobuf
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec that encodes to and decodes from Protocol Buffers.
+// Only values implementing proto.Message are supported.
+var Codec = protobufCodec{}
+
+type protobufCodec struct{}
+
+// Encode the proto message to a byte slice.
+func (c protobufCodec) Encode(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf: encode: %T does not implement proto.Message", v)
+	}
+
+	return proto.Marshal(m)
+}
+
+// Decode the byte slice to the proto message.
+func (c protobufCodec) Decode(b []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf: decode: %T does not implement proto.Message", v)
+	}
+
+	return proto.Unmarshal(b, m)
+}
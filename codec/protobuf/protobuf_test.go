@@ -0,0 +1,84 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package protobuf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/desertbit/pakt/codec/codectest"
+	"github.com/desertbit/pakt/codec/internal"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// protoEqual compares proto messages. The generated structs hold
+// internal state, which must not be compared with reflect.DeepEqual.
+func protoEqual(a, b interface{}) bool {
+	return proto.Equal(a.(proto.Message), b.(proto.Message))
+}
+
+func TestProtobuf(t *testing.T) {
+	internal.RoundtripTesterFunc(t, Codec, protoEqual, wrapperspb.String("test"), &wrapperspb.StringValue{})
+
+	s, err := structpb.NewStruct(map[string]interface{}{
+		"name": "test",
+		"list": []interface{}{1.0, "two", true},
+	})
+	require.NoError(t, err)
+	internal.RoundtripTesterFunc(t, Codec, protoEqual, s, &structpb.Struct{})
+}
+
+func TestConformance(t *testing.T) {
+	nested, err := structpb.NewStruct(map[string]interface{}{
+		"id":    42.0,
+		"null":  nil,
+		"item":  map[string]interface{}{"name": "item", "tags": []interface{}{"a", "b"}},
+		"items": []interface{}{map[string]interface{}{"name": "first"}, map[string]interface{}{}},
+		"empty": []interface{}{},
+	})
+	require.NoError(t, err)
+
+	// Protobuf only encodes messages, so the plain structs of Run can't be used.
+	codectest.RunFixtures(t, Codec,
+		codectest.Fixture{Name: "Empty", Value: &structpb.Struct{}, Equal: protoEqual},
+		codectest.Fixture{Name: "Nested", Value: nested, Equal: protoEqual},
+		codectest.Fixture{Name: "String", Value: wrapperspb.String("unicode ✓"), Equal: protoEqual},
+		codectest.Fixture{Name: "Time", Value: timestamppb.New(time.Date(2017, 12, 17, 10, 30, 15, 123456789, time.UTC)), Equal: protoEqual},
+		codectest.Fixture{Name: "Large", Value: wrapperspb.Bytes(make([]byte, 4*1024*1024)), Equal: protoEqual},
+	)
+}
+
+func TestProtobufInvalidType(t *testing.T) {
+	type notProto struct {
+		Name string
+	}
+
+	_, err := Codec.Encode(&notProto{Name: "test"})
+	require.EqualError(t, err, "protobuf: encode: *protobuf.notProto does not implement proto.Message")
+
+	err = Codec.Decode([]byte{}, &notProto{})
+	require.EqualError(t, err, "protobuf: decode: *protobuf.notProto does not implement proto.Message")
+
+	_, err = Codec.Encode(nil)
+	require.Error(t, err)
+}
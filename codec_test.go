@@ -0,0 +1,175 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package pakt_test
+
+import (
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/desertbit/pakt"
+	"github.com/desertbit/pakt/codec"
+	"github.com/desertbit/pakt/codec/cbor"
+	"github.com/desertbit/pakt/codec/gob"
+	"github.com/desertbit/pakt/codec/json"
+	"github.com/desertbit/pakt/codec/msgpack"
+	"github.com/desertbit/pakt/codec/protobuf"
+	"github.com/desertbit/pakt/memory"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// countingStreamCodec counts the calls of the stream methods.
+// Encode is still used for version 0 headers.
+type countingStreamCodec struct {
+	encodes int64
+	decodes int64
+}
+
+func (c *countingStreamCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Codec.Encode(v)
+}
+
+func (c *countingStreamCodec) Decode(b []byte, v interface{}) error {
+	return json.Codec.Decode(b, v)
+}
+
+func (c *countingStreamCodec) EncodeTo(w io.Writer, v interface{}) error {
+	atomic.AddInt64(&c.encodes, 1)
+	return json.Codec.EncodeTo(w, v)
+}
+
+func (c *countingStreamCodec) DecodeFrom(r io.Reader, v interface{}) error {
+	atomic.AddInt64(&c.decodes, 1)
+	return json.Codec.DecodeFrom(r, v)
+}
+
+func TestStreamCodec(t *testing.T) {
+	a, b := memory.Pipe()
+	sa := pakt.NewSocket(a)
+	sb := pakt.NewSocket(b)
+	defer sa.Close()
+
+	ca := &countingStreamCodec{}
+	cb := &countingStreamCodec{}
+	sa.Codec = ca
+	sb.Codec = cb
+	sa.SetMaxMessageSize(1024)
+
+	sb.RegisterFunc("echo", func(c *pakt.Context) (interface{}, error) {
+		var s string
+		err := c.Decode(&s)
+		return s, err
+	})
+
+	sa.Ready()
+	sb.Ready()
+
+	c, err := sa.Call("echo", "hello")
+	require.NoError(t, err)
+
+	var s string
+	require.NoError(t, c.Decode(&s))
+	require.Equal(t, "hello", s)
+
+	require.Equal(t, int64(1), atomic.LoadInt64(&ca.encodes))
+	require.Equal(t, int64(1), atomic.LoadInt64(&ca.decodes))
+	require.Equal(t, int64(1), atomic.LoadInt64(&cb.encodes))
+	require.Equal(t, int64(1), atomic.LoadInt64(&cb.decodes))
+
+	// The maximum message size is checked while encoding.
+	_, err = sa.Call("echo", strings.Repeat("x", 2048))
+	require.Equal(t, pakt.ErrMaxMsgSizeExceeded, err)
+}
+
+// trailingCodec appends trailing data to encoded strings.
+type trailingCodec struct {
+	codec.StreamCodec
+}
+
+func (c trailingCodec) EncodeTo(w io.Writer, v interface{}) error {
+	err := c.StreamCodec.EncodeTo(w, v)
+	if _, ok := v.(string); ok && err == nil {
+		_, err = w.Write([]byte{0})
+	}
+	return err
+}
+
+func TestStreamCodecTrailingData(t *testing.T) {
+	for name, c := range map[string]codec.StreamCodec{
+		"cbor":    cbor.Codec,
+		"gob":     gob.Codec,
+		"json":    json.Codec,
+		"msgpack": msgpack.Codec,
+	} {
+		t.Run(name, func(t *testing.T) {
+			a, b := memory.Pipe()
+			sa := pakt.NewSocket(a)
+			sb := pakt.NewSocket(b)
+			defer sa.Close()
+
+			sa.Codec = trailingCodec{c}
+			sb.Codec = c
+
+			sb.RegisterFunc("echo", func(c *pakt.Context) (interface{}, error) {
+				var s string
+				err := c.Decode(&s)
+				return s, err
+			})
+
+			sa.Ready()
+			sb.Ready()
+
+			_, err := sa.Call("echo", "hello")
+			require.Error(t, err)
+			require.Contains(t, err.Error(), codec.ErrTrailingData.Error())
+		})
+	}
+}
+
+func TestProtobufCall(t *testing.T) {
+	a, b := memory.Pipe()
+	sa := pakt.NewSocket(a)
+	sb := pakt.NewSocket(b)
+	defer sa.Close()
+
+	// Protobuf is not able to encode version 0 headers.
+	// Calls directly after Ready must wait for the hello of the peer.
+	sa.Codec = protobuf.Codec
+	sb.Codec = protobuf.Codec
+
+	sb.RegisterFunc("echo", func(c *pakt.Context) (interface{}, error) {
+		var v wrapperspb.StringValue
+		err := c.Decode(&v)
+		return &v, err
+	})
+
+	sa.Ready()
+	sb.Ready()
+
+	for i := 0; i < 3; i++ {
+		c, err := sa.Call("echo", wrapperspb.String("hello"))
+		require.NoError(t, err)
+
+		var v wrapperspb.StringValue
+		require.NoError(t, c.Decode(&v))
+		require.Equal(t, "hello", v.GetValue())
+	}
+}
@@ -0,0 +1,42 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package compress contains sub-packages with different compressors that can be
+// used to compress the PAKT message payloads.
+package compress
+
+import "errors"
+
+var (
+	// ErrMaxSizeExceeded defines the error if the decompressed data exceeds the maximum size.
+	ErrMaxSizeExceeded = errors.New("maximum decompressed size exceeded")
+)
+
+// Compressor represents a compressor used to compress and decompress payloads.
+// Implementations must be safe for concurrent use.
+type Compressor interface {
+	// Name returns the unique compressor name used to negotiate with the peer.
+	Name() string
+
+	// Compress the data.
+	Compress(b []byte) ([]byte, error)
+
+	// Decompress the data. Returns ErrMaxSizeExceeded if the
+	// decompressed data would exceed maxSize bytes.
+	Decompress(b []byte, maxSize int) ([]byte, error)
+}
@@ -0,0 +1,82 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package gzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/desertbit/pakt/compress"
+)
+
+// Compressor that compresses with gzip.
+var Compressor = gzipCompressor{}
+
+var writerPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+type gzipCompressor struct{}
+
+func (c gzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (c gzipCompressor) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w := writerPool.Get().(*gzip.Writer)
+	defer writerPool.Put(w)
+	w.Reset(&buf)
+
+	_, err := w.Write(b)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c gzipCompressor) Decompress(b []byte, maxSize int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// Read one byte more to detect if the maximum size is exceeded.
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	} else if len(data) > maxSize {
+		return nil, compress.ErrMaxSizeExceeded
+	}
+
+	return data, nil
+}
@@ -0,0 +1,52 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/desertbit/pakt/compress"
+)
+
+// RoundtripTester is a test helper to test a Compressor
+func RoundtripTester(t *testing.T, c compress.Compressor) {
+	data := bytes.Repeat([]byte("pakt compressor roundtrip "), 1000)
+
+	compressed, err := c.Compress(data)
+	if err != nil {
+		t.Fatal("Compress error:", err)
+	}
+	if len(compressed) >= len(data) {
+		t.Fatalf("Compressed size %v is not smaller than %v", len(compressed), len(data))
+	}
+
+	decompressed, err := c.Decompress(compressed, len(data))
+	if err != nil {
+		t.Fatal("Decompress error:", err)
+	}
+	if !bytes.Equal(data, decompressed) {
+		t.Fatal("Roundtrip compressor mismatch")
+	}
+
+	_, err = c.Decompress(compressed, len(data)-1)
+	if err != compress.ErrMaxSizeExceeded {
+		t.Fatalf("Expected ErrMaxSizeExceeded, got %v", err)
+	}
+}
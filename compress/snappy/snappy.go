@@ -0,0 +1,49 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package snappy
+
+import (
+	"github.com/desertbit/pakt/compress"
+	"github.com/golang/snappy"
+)
+
+// Compressor that compresses with snappy.
+// It is faster than gzip with a lower compression ratio.
+var Compressor = snappyCompressor{}
+
+type snappyCompressor struct{}
+
+func (c snappyCompressor) Name() string {
+	return "snappy"
+}
+
+func (c snappyCompressor) Compress(b []byte) ([]byte, error) {
+	return snappy.Encode(nil, b), nil
+}
+
+func (c snappyCompressor) Decompress(b []byte, maxSize int) ([]byte, error) {
+	n, err := snappy.DecodedLen(b)
+	if err != nil {
+		return nil, err
+	} else if n > maxSize {
+		return nil, compress.ErrMaxSizeExceeded
+	}
+
+	return snappy.Decode(nil, b)
+}
@@ -0,0 +1,114 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package pakt_test
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/desertbit/pakt"
+	"github.com/desertbit/pakt/compress"
+	"github.com/desertbit/pakt/compress/gzip"
+	"github.com/desertbit/pakt/compress/snappy"
+	"github.com/desertbit/pakt/memory"
+	"github.com/stretchr/testify/require"
+)
+
+type countingConn struct {
+	net.Conn
+	written int64
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	atomic.AddInt64(&c.written, int64(len(b)))
+	return c.Conn.Write(b)
+}
+
+// testCompression returns the compressor names used by both sockets
+// and the number of bytes written by the first socket.
+func testCompression(t *testing.T, ca, cb []compress.Compressor) (string, string, int64) {
+	a, b := memory.Pipe()
+	ac := &countingConn{Conn: a}
+
+	sa := pakt.NewSocket(ac)
+	sb := pakt.NewSocket(b)
+	defer sa.Close()
+
+	if ca != nil {
+		sa.SetCompression(-1, ca...)
+	}
+	if cb != nil {
+		sb.SetCompression(-1, cb...)
+	}
+
+	sb.RegisterFunc("echo", func(c *pakt.Context) (interface{}, error) {
+		var s string
+		err := c.Decode(&s)
+		return s, err
+	})
+
+	sa.Ready()
+	sb.Ready()
+
+	// Small payloads are sent uncompressed.
+	// The negotiation is done as soon as the first call returns.
+	c, err := sa.Call("echo", "small")
+	require.NoError(t, err)
+
+	var s string
+	require.NoError(t, c.Decode(&s))
+	require.Equal(t, "small", s)
+
+	data := strings.Repeat("compressible payload ", 2000)
+
+	c, err = sa.Call("echo", data)
+	require.NoError(t, err)
+	require.NoError(t, c.Decode(&s))
+	require.Equal(t, data, s)
+
+	return sa.PeerCompressor(), sb.PeerCompressor(), atomic.LoadInt64(&ac.written)
+}
+
+func TestCompression(t *testing.T) {
+	na, nb, uncompressed := testCompression(t, nil, nil)
+	require.Equal(t, "", na)
+	require.Equal(t, "", nb)
+
+	// Peers without compression support interoperate.
+	na, nb, n := testCompression(t, []compress.Compressor{gzip.Compressor}, nil)
+	require.Equal(t, "", na)
+	require.Equal(t, "", nb)
+	require.Equal(t, uncompressed+int64(len("compress/gzip")+1), n)
+
+	// The first compressor supported by the peer is chosen per direction.
+	na, nb, n = testCompression(t,
+		[]compress.Compressor{snappy.Compressor, gzip.Compressor},
+		[]compress.Compressor{gzip.Compressor})
+	require.Equal(t, "gzip", na)
+	require.Equal(t, "gzip", nb)
+	require.True(t, n < uncompressed/10, n)
+
+	na, nb, _ = testCompression(t,
+		[]compress.Compressor{snappy.Compressor, gzip.Compressor},
+		[]compress.Compressor{gzip.Compressor, snappy.Compressor})
+	require.Equal(t, "snappy", na)
+	require.Equal(t, "gzip", nb)
+}
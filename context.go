@@ -19,13 +19,19 @@
 package pakt
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+
+	"github.com/desertbit/pakt/codec"
 )
 
 var (
 	// ErrNoContextData defines the error if no context data is available.
 	ErrNoContextData = errors.New("no context data available to decode")
+
+	// ErrNoRawData defines the error if the context data is not raw.
+	ErrNoRawData = errors.New("context data is not raw")
 )
 
 //####################//
@@ -38,12 +44,15 @@ type Context struct {
 	Data []byte
 
 	socket *Socket
+	raw    bool
+	funcID string
 }
 
-func newContext(s *Socket, data []byte) *Context {
+func newContext(s *Socket, data []byte, raw bool) *Context {
 	return &Context{
 		socket: s,
 		Data:   data,
+		raw:    raw,
 	}
 }
 
@@ -52,20 +61,63 @@ func (c *Context) Socket() *Socket {
 	return c.socket
 }
 
+// IsRaw returns a boolean indicating if the context data
+// was sent as raw data without encoding.
+func (c *Context) IsRaw() bool {
+	return c.raw
+}
+
+// Raw returns the raw context data without copying it.
+// Returns ErrNoRawData if the data was encoded with the codec.
+func (c *Context) Raw() ([]byte, error) {
+	if !c.raw {
+		return nil, ErrNoRawData
+	}
+	return c.Data, nil
+}
+
 // Decode the context data to a custom value.
 // The value has to be passed as pointer.
+// Raw data can only be decoded to a *[]byte or *Raw value without copying it.
+// Decoded values of function calls are validated by their Validator
+// implementation and the validate function registered for the function ID.
+// Returns a *ValidationError if the value is invalid.
 // Returns ErrNoContextData if there is no context data available to decode.
 func (c *Context) Decode(v interface{}) error {
+	if c.raw {
+		switch d := v.(type) {
+		case *[]byte:
+			*d = c.Data
+		case *Raw:
+			*d = c.Data
+		default:
+			return fmt.Errorf("decode: raw data can't be decoded to %T", v)
+		}
+		return nil
+	}
+
 	// Check if no data was passed.
 	if len(c.Data) == 0 {
 		return ErrNoContextData
 	}
 
-	// Decode the data.
-	err := c.socket.Codec.Decode(c.Data, v)
+	// Decode the data. Stream codecs decode directly from the data
+	// and reject trailing data.
+	var err error
+	if sc, ok := c.socket.Codec.(codec.StreamCodec); ok {
+		err = sc.DecodeFrom(bytes.NewReader(c.Data), v)
+	} else {
+		err = c.socket.Codec.Decode(c.Data, v)
+	}
 	if err != nil {
 		return fmt.Errorf("decode: %v", err)
 	}
 
+	// Return validation errors unwrapped, so they are sent to the caller.
+	err = c.socket.validate(c.funcID, v)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
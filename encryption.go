@@ -0,0 +1,488 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package pakt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// MinKeySize specifies the minimum size of a pre-shared key in bytes.
+	MinKeySize = 16
+
+	connNonceSize      = 16
+	encryptionInfoSize = 12 // Key ID (uint32) + sequence number (uint64).
+	gcmOverhead        = 16
+	gcmNonceSize       = 12
+	encryptionADSize   = 8 + encryptionInfoSize // Message head + info.
+	featureNoncePrefix = "nonce/"
+	keyDerivationLabel = "pakt encryption key"
+	helloMACLabel      = "pakt hello mac"
+	helloMACSize       = 4 + sha256.Size // Key ID (uint32) + MAC.
+)
+
+var (
+	// ErrNoSendKey defines the error if the keyring has no key to encrypt messages.
+	ErrNoSendKey = errors.New("keyring: no send key set")
+
+	errPeerNotReady = errors.New("peer hello not received within timeout")
+	errPeerLegacy   = errors.New("peer does not send a hello (protocol version 0)")
+)
+
+//###################//
+//### Keyring Type ###//
+//###################//
+
+// Keyring holds the pre-shared keys used to encrypt and authenticate messages.
+// Every key is identified by an ID, which is sent with each message.
+// To rotate keys, add the new key to the keyrings of both peers,
+// switch the send key and remove the old key as soon as all peers switched.
+// Key IDs must not be reused for different keys.
+// All methods are thread-safe.
+type Keyring struct {
+	mutex      sync.RWMutex
+	keys       map[uint32][]byte
+	sendKeyID  uint32
+	hasSendKey bool
+}
+
+// NewKeyring creates a new keyring with the key used as send key.
+func NewKeyring(id uint32, key []byte) (*Keyring, error) {
+	k := &Keyring{
+		keys: make(map[uint32][]byte),
+	}
+
+	err := k.AddKey(id, key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = k.SetSendKey(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// AddKey adds a key to decrypt messages.
+// The key must have at least MinKeySize bytes.
+func (k *Keyring) AddKey(id uint32, key []byte) error {
+	if len(key) < MinKeySize {
+		return fmt.Errorf("keyring: key must have at least %v bytes", MinKeySize)
+	}
+
+	// Copy the key, because the caller may modify the slice.
+	c := make([]byte, len(key))
+	copy(c, key)
+
+	k.mutex.Lock()
+	k.keys[id] = c
+	k.mutex.Unlock()
+
+	return nil
+}
+
+// RemoveKey removes the key. The send key can't be removed.
+func (k *Keyring) RemoveKey(id uint32) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.hasSendKey && k.sendKeyID == id {
+		return fmt.Errorf("keyring: can't remove send key: %v", id)
+	}
+
+	delete(k.keys, id)
+	return nil
+}
+
+// SetSendKey sets the key used to encrypt messages.
+func (k *Keyring) SetSendKey(id uint32) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("keyring: unknown key: %v", id)
+	}
+
+	k.sendKeyID = id
+	k.hasSendKey = true
+	return nil
+}
+
+func (k *Keyring) sendKey() (id uint32, key []byte, err error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	if !k.hasSendKey {
+		return 0, nil, ErrNoSendKey
+	}
+	return k.sendKeyID, k.keys[k.sendKeyID], nil
+}
+
+func (k *Keyring) key(id uint32) (key []byte, ok bool) {
+	k.mutex.RLock()
+	key, ok = k.keys[id]
+	k.mutex.RUnlock()
+	return
+}
+
+//##############//
+//### Socket ###//
+//##############//
+
+// SetEncryption enables the authenticated encryption of all messages with
+// the keys of the keyring. Both peers must enable encryption with the same keys.
+// Unencrypted messages are rejected. The keys used for a connection are derived
+// with HKDF from the pre-shared keys, the random nonces of both peers exchanged
+// in the hello messages and the direction, so messages can't be replayed to
+// other connections, even if a hello message was replayed. Sequence numbers
+// protect against replayed and reordered messages within a connection.
+// The first encrypted message of each peer holds a MAC of both hello messages,
+// so modified hello messages, like downgraded features, are detected.
+// Messages are only sent after the hello message of the peer was received.
+// Only set this during initialization before calling Ready.
+func (s *Socket) SetEncryption(k *Keyring) error {
+	nonce := make([]byte, connNonceSize)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+
+	s.keyring = k
+	s.connNonce = nonce
+	return nil
+}
+
+//###############//
+//### Private ###//
+//###############//
+
+type encryptionState struct {
+	keyring   *Keyring
+	connNonce []byte
+
+	// Guarded by the write mutex.
+	sendSeq      uint64
+	sendNonce    [gcmNonceSize]byte
+	sendAD       [encryptionADSize]byte
+	helloMACSent bool
+
+	// Only accessed by the read loop.
+	recvSeq          uint64
+	recvNonce        [gcmNonceSize]byte
+	recvAD           [encryptionADSize]byte
+	helloMACVerified bool
+
+	aeadMutex sync.Mutex
+	aeads     map[aeadKey]*derivedAEAD
+}
+
+type aeadKey struct {
+	ID   uint32
+	Recv bool
+}
+
+type derivedAEAD struct {
+	Key  []byte
+	AEAD cipher.AEAD
+}
+
+// getAEAD returns the cipher for the pre-shared key derived with the nonces
+// of the sending and the receiving peer.
+func (e *encryptionState) getAEAD(id uint32, key, senderNonce, receiverNonce []byte, recv bool) (cipher.AEAD, error) {
+	e.aeadMutex.Lock()
+	defer e.aeadMutex.Unlock()
+
+	if e.aeads == nil {
+		e.aeads = make(map[aeadKey]*derivedAEAD)
+	}
+
+	k := aeadKey{ID: id, Recv: recv}
+	if d, ok := e.aeads[k]; ok && bytes.Equal(d.Key, key) {
+		return d.AEAD, nil
+	}
+
+	derived := deriveKey(key, keyDerivationLabel, senderNonce, receiverNonce)
+
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	e.aeads[k] = &derivedAEAD{Key: key, AEAD: aead}
+	return aead, nil
+}
+
+// deriveKey derives a key with HKDF-SHA256 (RFC 5869) from the pre-shared key.
+// The nonces have a fixed size and their order defines the direction.
+func deriveKey(key []byte, label string, senderNonce, receiverNonce []byte) []byte {
+	// Extract without salt.
+	extract := hmac.New(sha256.New, make([]byte, sha256.Size))
+	extract.Write(key)
+
+	// One expand block is sufficient for a SHA-256 sized key.
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte(label))
+	expand.Write(senderNonce)
+	expand.Write(receiverNonce)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
+
+// checkPeerNonce checks the nonce announced by the peer.
+// Hello messages reflected to their sender are rejected, because
+// both directions would use the same keys.
+func (s *Socket) checkPeerNonce(p *peerInfo) error {
+	if len(p.Nonce) != connNonceSize {
+		return errors.New("encryption: invalid peer nonce")
+	} else if bytes.Equal(p.Nonce, s.connNonce) {
+		return errors.New("encryption: reflected hello")
+	}
+	return nil
+}
+
+// helloMAC returns the MAC of the hello messages sent by both peers.
+// The MAC key is derived from the pre-shared key and the nonces.
+func helloMAC(key, senderNonce, receiverNonce, senderHello, receiverHello []byte) []byte {
+	mac := hmac.New(sha256.New, deriveKey(key, helloMACLabel, senderNonce, receiverNonce))
+
+	var l [4]byte
+	for _, h := range [][]byte{senderHello, receiverHello} {
+		endian.PutUint32(l[:], uint32(len(h)))
+		mac.Write(l[:])
+		mac.Write(h)
+	}
+	return mac.Sum(nil)
+}
+
+// appendHelloMAC adds the message authenticating the hello messages.
+// It is the first encrypted message sent to the peer.
+// Must be called with the write mutex locked.
+func (s *Socket) appendHelloMAC() error {
+	s.helloMACSent = true
+
+	id, key, err := s.keyring.sendKey()
+	if err != nil {
+		return err
+	}
+
+	sent, peer := s.helloTranscript()
+	payload := make([]byte, 4, helloMACSize)
+	endian.PutUint32(payload, id)
+	payload = append(payload, helloMAC(key, s.connNonce, peer.Nonce, sent, peer.Hello)...)
+
+	return s.appendFrame(s.sendVersion(), typeHelloMAC, nil, payload)
+}
+
+// verifyHelloMAC verifies the MAC of the hello messages sent by the peer.
+// Must only be called by the read loop.
+func (s *Socket) verifyHelloMAC(payload []byte) error {
+	if s.helloMACVerified {
+		return errors.New("duplicate hello mac")
+	} else if len(payload) != helloMACSize {
+		return errors.New("invalid hello mac size")
+	}
+
+	id := endian.Uint32(payload[:4])
+	key, ok := s.keyring.key(id)
+	if !ok {
+		return fmt.Errorf("hello mac: unknown key: %v", id)
+	}
+
+	sent, peer := s.helloTranscript()
+	if !hmac.Equal(payload[4:], helloMAC(key, peer.Nonce, s.connNonce, peer.Hello, sent)) {
+		return errors.New("invalid hello mac")
+	}
+
+	s.helloMACVerified = true
+	return nil
+}
+
+// waitPeerHello blocks until the hello of the peer was received.
+// Returns errPeerLegacy as soon as the peer is identified as version 0 peer.
+func (s *Socket) waitPeerHello() error {
+	select {
+	case <-s.peerHelloChan:
+		return nil
+	case <-s.peerLegacyChan:
+		return errPeerLegacy
+	default:
+	}
+
+	// Peers of version 0 don't send a hello. Probe them with a ping, so
+	// they are identified by their pong without waiting for their next
+	// message. Encrypted messages are only sent after the hello.
+	if s.keyring == nil {
+		s.peerProbeOnce.Do(func() {
+			err := s.write(0, typePing, nil, nil)
+			if err != nil {
+				Log.Warningf("socket: failed to send ping request: %v", err)
+			}
+		})
+	}
+
+	timeout := time.NewTimer(writeTimeout)
+	defer timeout.Stop()
+
+	select {
+	case <-s.peerHelloChan:
+		return nil
+	case <-s.peerLegacyChan:
+		return errPeerLegacy
+	case <-s.closingChan:
+		return ErrClosed
+	case <-timeout.C:
+		return errPeerNotReady
+	}
+}
+
+// setEncryptionAD sets the additional authenticated data of a message.
+func setEncryptionAD(ad *[encryptionADSize]byte, head, info []byte) {
+	n := copy(ad[:], head)
+	copy(ad[n:], info)
+}
+
+// setGCMNonce sets the nonce for the sequence number.
+// The header and the payload of a message use different nonces.
+func setGCMNonce(nonce *[gcmNonceSize]byte, seq uint64, payload bool) {
+	endian.PutUint64(nonce[:8], seq)
+	nonce[8], nonce[9], nonce[10], nonce[11] = 0, 0, 0, 0
+	if payload {
+		nonce[11] = 1
+	}
+}
+
+// encryptFrame encrypts the header and payload into pooled buffers, which
+// must be released with putBuffer. The encrypted header is prefixed with the
+// key ID and sequence number. The encrypted payload is nil if the payload is
+// empty. The head must already contain the final lengths.
+// Must be called with the write mutex locked.
+func (s *Socket) encryptFrame(head, header, payload []byte) (encHeader, encPayload *[]byte, err error) {
+	peer := s.getPeer()
+	if peer == nil || len(peer.Nonce) == 0 {
+		return nil, nil, errors.New("encryption: missing peer nonce")
+	}
+
+	id, key, err := s.keyring.sendKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aead, err := s.getAEAD(id, key, s.connNonce, peer.Nonce, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.sendSeq++
+	seq := s.sendSeq
+
+	encHeader = getBuffer(encryptionInfoSize + len(header) + gcmOverhead)
+	info := (*encHeader)[:encryptionInfoSize]
+	endian.PutUint32(info[0:4], id)
+	endian.PutUint64(info[4:12], seq)
+
+	setEncryptionAD(&s.sendAD, head, info)
+
+	setGCMNonce(&s.sendNonce, seq, false)
+	*encHeader = aead.Seal(info, s.sendNonce[:], header, s.sendAD[:])
+
+	if len(payload) > 0 {
+		encPayload = getBuffer(len(payload) + gcmOverhead)
+		setGCMNonce(&s.sendNonce, seq, true)
+		*encPayload = aead.Seal((*encPayload)[:0], s.sendNonce[:], payload, s.sendAD[:])
+	}
+
+	return encHeader, encPayload, nil
+}
+
+// decryptFrame decrypts and authenticates the header and payload in place.
+// Must only be called by the read loop.
+func (s *Socket) decryptFrame(head, header, payload []byte) ([]byte, []byte, error) {
+	if len(header) < encryptionInfoSize {
+		return nil, nil, errors.New("decrypt: invalid header length")
+	}
+
+	info := header[:encryptionInfoSize]
+	id := endian.Uint32(info[0:4])
+	seq := endian.Uint64(info[4:12])
+
+	// Reject replayed and reordered messages.
+	if seq <= s.recvSeq {
+		return nil, nil, fmt.Errorf("decrypt: invalid sequence number: %v <= %v", seq, s.recvSeq)
+	}
+
+	key, ok := s.keyring.key(id)
+	if !ok {
+		return nil, nil, fmt.Errorf("decrypt: unknown key: %v", id)
+	}
+
+	peer := s.getPeer()
+	if peer == nil {
+		return nil, nil, errors.New("decrypt: missing peer nonce")
+	}
+
+	aead, err := s.getAEAD(id, key, peer.Nonce, s.connNonce, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	setEncryptionAD(&s.recvAD, head, info)
+
+	ciphertext := header[encryptionInfoSize:]
+	setGCMNonce(&s.recvNonce, seq, false)
+	header, err = aead.Open(ciphertext[:0], s.recvNonce[:], ciphertext, s.recvAD[:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("decrypt header: %v", err)
+	}
+
+	if len(payload) > 0 {
+		setGCMNonce(&s.recvNonce, seq, true)
+		payload, err = aead.Open(payload[:0], s.recvNonce[:], payload, s.recvAD[:])
+		if err != nil {
+			return nil, nil, fmt.Errorf("decrypt payload: %v", err)
+		}
+	}
+
+	s.recvSeq = seq
+	return header, payload, nil
+}
+
+// encryptedSizes returns the sizes of the encrypted header and payload.
+func encryptedSizes(headerLen, payloadLen int) (int, int) {
+	headerLen += encryptionInfoSize + gcmOverhead
+	if payloadLen > 0 {
+		payloadLen += gcmOverhead
+	}
+	return headerLen, payloadLen
+}
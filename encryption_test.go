@@ -0,0 +1,374 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package pakt_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/desertbit/pakt"
+	"github.com/desertbit/pakt/compress/gzip"
+	"github.com/desertbit/pakt/memory"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testKey1 = []byte("0123456789abcdef0123456789abcdef")
+	testKey2 = []byte("fedcba9876543210fedcba9876543210")
+)
+
+// recordingConn records all written bytes and optionally
+// writes every write after the first one twice.
+type recordingConn struct {
+	net.Conn
+	duplicate bool
+
+	mutex   sync.Mutex
+	writes  int
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	c.writes++
+	duplicate := c.duplicate && c.writes > 1
+	c.written.Write(b)
+	c.mutex.Unlock()
+
+	n, err := c.Conn.Write(b)
+	if err == nil && duplicate {
+		_, err = c.Conn.Write(b)
+	}
+	return n, err
+}
+
+func (c *recordingConn) Contains(b []byte) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return bytes.Contains(c.written.Bytes(), b)
+}
+
+func newKeyring(t *testing.T, id uint32, key []byte) *pakt.Keyring {
+	k, err := pakt.NewKeyring(id, key)
+	require.NoError(t, err)
+	return k
+}
+
+func newEncryptedPair(t *testing.T, ka, kb *pakt.Keyring, duplicate bool) (*pakt.Socket, *pakt.Socket, *recordingConn) {
+	a, b := memory.Pipe()
+	ac := &recordingConn{Conn: a, duplicate: duplicate}
+
+	sa := pakt.NewSocket(ac)
+	sb := pakt.NewSocket(b)
+
+	require.NoError(t, sa.SetEncryption(ka))
+	require.NoError(t, sb.SetEncryption(kb))
+
+	sb.SetCallTimeout(time.Second)
+	sa.SetCallTimeout(time.Second)
+
+	echo := func(c *pakt.Context) (interface{}, error) {
+		var s string
+		err := c.Decode(&s)
+		return s, err
+	}
+	sa.RegisterFunc("echo", echo)
+	sb.RegisterFunc("echo", echo)
+
+	sa.Ready()
+	sb.Ready()
+
+	return sa, sb, ac
+}
+
+func callEcho(s *pakt.Socket, data string) error {
+	c, err := s.Call("echo", data)
+	if err != nil {
+		return err
+	}
+
+	var r string
+	err = c.Decode(&r)
+	if err != nil {
+		return err
+	}
+	if r != data {
+		return pakt.ErrClosed
+	}
+	return nil
+}
+
+func TestKeyring(t *testing.T) {
+	_, err := pakt.NewKeyring(1, []byte("short"))
+	require.Error(t, err)
+
+	k := newKeyring(t, 1, testKey1)
+	require.Error(t, k.SetSendKey(2))
+	require.Error(t, k.RemoveKey(1))
+
+	require.NoError(t, k.AddKey(2, testKey2))
+	require.NoError(t, k.SetSendKey(2))
+	require.NoError(t, k.RemoveKey(1))
+}
+
+func TestEncryption(t *testing.T) {
+	ka := newKeyring(t, 1, testKey1)
+	kb := newKeyring(t, 1, testKey1)
+
+	sa, sb, ac := newEncryptedPair(t, ka, kb, false)
+	defer sa.Close()
+
+	secret := "secret payload"
+	require.NoError(t, callEcho(sa, secret))
+	require.NoError(t, callEcho(sb, secret))
+	require.False(t, ac.Contains([]byte(secret)))
+	require.False(t, ac.Contains([]byte("echo")))
+
+	// Rotate the keys. The old key is removed after both peers switched.
+	require.NoError(t, ka.AddKey(2, testKey2))
+	require.NoError(t, kb.AddKey(2, testKey2))
+	require.NoError(t, ka.SetSendKey(2))
+	require.NoError(t, callEcho(sa, secret))
+	require.NoError(t, kb.SetSendKey(2))
+	require.NoError(t, ka.RemoveKey(1))
+	require.NoError(t, kb.RemoveKey(1))
+	require.NoError(t, callEcho(sa, secret))
+	require.False(t, sa.IsClosed())
+}
+
+func TestEncryptionCompression(t *testing.T) {
+	a, b := memory.Pipe()
+	ac := &recordingConn{Conn: a}
+
+	sa := pakt.NewSocket(ac)
+	sb := pakt.NewSocket(b)
+	defer sa.Close()
+
+	require.NoError(t, sa.SetEncryption(newKeyring(t, 1, testKey1)))
+	require.NoError(t, sb.SetEncryption(newKeyring(t, 1, testKey1)))
+	sa.SetCompression(-1, gzip.Compressor)
+	sb.SetCompression(-1, gzip.Compressor)
+
+	sb.RegisterFunc("echo", func(c *pakt.Context) (interface{}, error) {
+		var s string
+		err := c.Decode(&s)
+		return s, err
+	})
+
+	sa.Ready()
+	sb.Ready()
+
+	data := strings.Repeat("compressible payload ", 2000)
+	require.NoError(t, callEcho(sa, data))
+	require.Equal(t, "gzip", sa.PeerCompressor())
+	require.False(t, ac.Contains([]byte("compressible payload")))
+}
+
+func TestEncryptionWrongKey(t *testing.T) {
+	sa, sb, _ := newEncryptedPair(t, newKeyring(t, 1, testKey1), newKeyring(t, 1, testKey2), false)
+	defer sa.Close()
+
+	require.Error(t, callEcho(sa, "data"))
+
+	select {
+	case <-sb.ClosedChan():
+	case <-time.After(time.Second):
+		t.Fatal("socket not closed")
+	}
+}
+
+func TestEncryptionUnknownKey(t *testing.T) {
+	sa, sb, _ := newEncryptedPair(t, newKeyring(t, 2, testKey1), newKeyring(t, 1, testKey1), false)
+	defer sa.Close()
+
+	require.Error(t, callEcho(sa, "data"))
+
+	select {
+	case <-sb.ClosedChan():
+	case <-time.After(time.Second):
+		t.Fatal("socket not closed")
+	}
+}
+
+func TestEncryptionReplay(t *testing.T) {
+	// Every encrypted message is written twice.
+	sa, sb, _ := newEncryptedPair(t, newKeyring(t, 1, testKey1), newKeyring(t, 1, testKey1), true)
+	defer sa.Close()
+
+	_ = callEcho(sa, "data")
+
+	select {
+	case <-sb.ClosedChan():
+	case <-time.After(time.Second):
+		t.Fatal("replayed message not rejected")
+	}
+}
+
+func TestEncryptionRequired(t *testing.T) {
+	a, b := memory.Pipe()
+
+	sa := pakt.NewSocket(a)
+	sb := pakt.NewSocket(b)
+	defer sa.Close()
+
+	require.NoError(t, sb.SetEncryption(newKeyring(t, 1, testKey1)))
+	sa.SetCallTimeout(time.Second)
+
+	sb.RegisterFunc("echo", func(c *pakt.Context) (interface{}, error) {
+		return nil, nil
+	})
+
+	sa.Ready()
+	sb.Ready()
+
+	// Unencrypted messages are rejected.
+	require.Error(t, callEcho(sa, "data"))
+
+	select {
+	case <-sb.ClosedChan():
+	case <-time.After(time.Second):
+		t.Fatal("socket not closed")
+	}
+}
+
+// readFrame reads one frame from the connection.
+func readFrame(t *testing.T, conn net.Conn) []byte {
+	frame := make([]byte, 8)
+	_, err := io.ReadFull(conn, frame)
+	require.NoError(t, err)
+
+	l := int(binary.BigEndian.Uint16(frame[2:4])) + int(binary.BigEndian.Uint32(frame[4:8]))
+	frame = append(frame, make([]byte, l)...)
+	_, err = io.ReadFull(conn, frame[8:])
+	require.NoError(t, err)
+	return frame
+}
+
+func TestEncryptionReplayedHello(t *testing.T) {
+	k := newKeyring(t, 1, testKey1)
+
+	// Record the hello of a peer.
+	a, b := net.Pipe()
+	sa := pakt.NewSocket(a)
+	require.NoError(t, sa.SetEncryption(k))
+	sa.Ready()
+	hello := readFrame(t, b)
+	b.Close()
+	sa.Close()
+
+	// Replay the hello to new sockets and return the encrypted call.
+	call := func() []byte {
+		a, b := net.Pipe()
+		s := pakt.NewSocket(a)
+		defer s.Close()
+		defer b.Close()
+
+		require.NoError(t, s.SetEncryption(k))
+		s.Ready()
+
+		readFrame(t, b)
+		_, err := b.Write(hello)
+		require.NoError(t, err)
+
+		go s.Call("echo", "secret")
+
+		readFrame(t, b) // Hello MAC.
+		return readFrame(t, b)
+	}
+
+	// The keys depend on the nonces of both peers.
+	require.NotEqual(t, call(), call())
+}
+
+func TestEncryptionReflectedHello(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	s := pakt.NewSocket(a)
+	require.NoError(t, s.SetEncryption(newKeyring(t, 1, testKey1)))
+	s.Ready()
+
+	hello := readFrame(t, b)
+	_, err := b.Write(hello)
+	require.NoError(t, err)
+
+	select {
+	case <-s.ClosedChan():
+	case <-time.After(time.Second):
+		t.Fatal("reflected hello not rejected")
+	}
+}
+
+// strippingConn removes the compression feature from the first written frame.
+type strippingConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (c *strippingConn) Write(b []byte) (int, error) {
+	n := len(b)
+	c.once.Do(func() {
+		f := []byte("compress/gzip")
+		i := bytes.Index(b, f)
+		if i < 0 {
+			return
+		}
+
+		s := append([]byte(nil), b[:i-1]...)
+		s = append(s, b[i+len(f):]...)
+		binary.BigEndian.PutUint32(s[4:8], binary.BigEndian.Uint32(s[4:8])-uint32(len(f)+1))
+		b = s
+	})
+	_, err := c.Conn.Write(b)
+	return n, err
+}
+
+func TestEncryptionDowngradedHello(t *testing.T) {
+	a, b := memory.Pipe()
+
+	sa := pakt.NewSocket(&strippingConn{Conn: a})
+	sb := pakt.NewSocket(b)
+	defer sa.Close()
+
+	require.NoError(t, sa.SetEncryption(newKeyring(t, 1, testKey1)))
+	require.NoError(t, sb.SetEncryption(newKeyring(t, 1, testKey1)))
+	sa.SetCompression(-1, gzip.Compressor)
+	sb.SetCompression(-1, gzip.Compressor)
+	sa.SetCallTimeout(time.Second)
+
+	sb.RegisterFunc("echo", func(c *pakt.Context) (interface{}, error) {
+		return nil, nil
+	})
+
+	sa.Ready()
+	sb.Ready()
+
+	require.Error(t, callEcho(sa, "data"))
+
+	select {
+	case <-sb.ClosedChan():
+	case <-time.After(time.Second):
+		t.Fatal("downgraded hello not detected")
+	}
+}
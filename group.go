@@ -0,0 +1,257 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package pakt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/desertbit/pakt/codec"
+)
+
+//####################//
+//### Server Group ###//
+//####################//
+
+// BroadcastError holds the errors of the sockets which failed to receive a broadcast.
+type BroadcastError struct {
+	// Errors maps the socket IDs to their errors.
+	Errors map[string]error
+}
+
+// Error implements the error interface.
+func (e *BroadcastError) Error() string {
+	ids := make([]string, 0, len(e.Errors))
+	for id := range e.Errors {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	msgs := make([]string, len(ids))
+	for i, id := range ids {
+		msgs[i] = id + ": " + e.Errors[id].Error()
+	}
+	return fmt.Sprintf("broadcast failed for %v sockets: %v", len(ids), strings.Join(msgs, "; "))
+}
+
+// AddToGroup adds the socket to the named group.
+// The socket is removed from all groups as soon as it closes.
+// This method is thread-safe.
+func (s *Server) AddToGroup(group string, so *Socket) {
+	s.groupsMutex.Lock()
+	defer s.groupsMutex.Unlock()
+
+	g := s.groups[group]
+	if g == nil {
+		g = make(map[*Socket]struct{})
+		s.groups[group] = g
+	}
+	g[so] = struct{}{}
+
+	sg := s.socketGroups[so]
+	if sg == nil {
+		sg = make(map[string]struct{})
+		s.socketGroups[so] = sg
+
+		// Remove the socket from all groups on close.
+		// If already closed, it is removed immediately.
+		go func() {
+			<-so.closeChan
+			s.removeFromGroups(so)
+		}()
+	}
+	sg[group] = struct{}{}
+}
+
+// RemoveFromGroup removes the socket from the named group.
+// This method is thread-safe.
+func (s *Server) RemoveFromGroup(group string, so *Socket) {
+	s.groupsMutex.Lock()
+	defer s.groupsMutex.Unlock()
+
+	if g := s.groups[group]; g != nil {
+		delete(g, so)
+		if len(g) == 0 {
+			delete(s.groups, group)
+		}
+	}
+
+	// The close routine keeps running if the socket is removed from
+	// all groups. It is released as soon as the socket closes.
+	if sg := s.socketGroups[so]; sg != nil {
+		delete(sg, group)
+	}
+}
+
+// GroupSockets returns the sockets of the named group.
+// This method is thread-safe.
+func (s *Server) GroupSockets(group string) []*Socket {
+	s.groupsMutex.RLock()
+	defer s.groupsMutex.RUnlock()
+
+	g := s.groups[group]
+	list := make([]*Socket, 0, len(g))
+	for so := range g {
+		list = append(list, so)
+	}
+	return list
+}
+
+// Groups returns the names of the groups with at least one socket.
+// This method is thread-safe.
+func (s *Server) Groups() []string {
+	s.groupsMutex.RLock()
+	defer s.groupsMutex.RUnlock()
+
+	list := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		list = append(list, name)
+	}
+	return list
+}
+
+// Broadcast calls the function of all sockets in the group concurrently and
+// waits until all calls returned. The data is encoded once for all sockets
+// using the same codec. Returns a *BroadcastError holding the error of each
+// socket, which failed to receive the call or returned an error.
+// One variadic argument specifies an optional call timeout.
+// This method is thread-safe.
+func (s *Server) Broadcast(group, funcID string, data interface{}, timeout ...time.Duration) error {
+	sockets := s.GroupSockets(group)
+	if len(sockets) == 0 {
+		return nil
+	}
+
+	// Raw readers can only be read once.
+	if r, ok := data.(RawReader); ok {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("broadcast: read raw data: %v", err)
+		}
+		data = Raw(b)
+	}
+
+	// Encode the data once per codec.
+	var encoded []broadcastPayload
+	payloads := make([]interface{}, len(sockets))
+	for i, so := range sockets {
+		p, err := encodeBroadcastPayload(&encoded, so.Codec, data)
+		if err != nil {
+			return fmt.Errorf("broadcast: encode: %v", err)
+		}
+		payloads[i] = p
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errMutex sync.Mutex
+		errs     map[string]error
+	)
+
+	for i, so := range sockets {
+		args := []interface{}{payloads[i]}
+		if len(timeout) > 0 {
+			args = append(args, timeout[0])
+		}
+
+		wg.Add(1)
+		go func(so *Socket) {
+			defer wg.Done()
+
+			_, err := so.Call(funcID, args...)
+			if err != nil {
+				errMutex.Lock()
+				if errs == nil {
+					errs = make(map[string]error)
+				}
+				errs[so.ID()] = err
+				errMutex.Unlock()
+			}
+		}(so)
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return &BroadcastError{Errors: errs}
+	}
+	return nil
+}
+
+//###############//
+//### Private ###//
+//###############//
+
+// encodedData holds a payload already encoded with the socket's codec.
+type encodedData []byte
+
+type broadcastPayload struct {
+	Codec codec.Codec
+	Data  encodedData
+}
+
+// encodeBroadcastPayload returns the encoded data for the codec.
+// Encoded payloads are reused for equal codecs.
+func encodeBroadcastPayload(encoded *[]broadcastPayload, c codec.Codec, data interface{}) (interface{}, error) {
+	switch data.(type) {
+	case nil, Raw:
+		return data, nil
+	}
+
+	for _, p := range *encoded {
+		if sameCodec(p.Codec, c) {
+			return p.Data, nil
+		}
+	}
+
+	b, err := c.Encode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	*encoded = append(*encoded, broadcastPayload{Codec: c, Data: b})
+	return encodedData(b), nil
+}
+
+// sameCodec returns a boolean indicating if both codecs are equal.
+// Codecs of incomparable types are never equal.
+func sameCodec(a, b codec.Codec) bool {
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
+
+func (s *Server) removeFromGroups(so *Socket) {
+	s.groupsMutex.Lock()
+	defer s.groupsMutex.Unlock()
+
+	for group := range s.socketGroups[so] {
+		if g := s.groups[group]; g != nil {
+			delete(g, so)
+			if len(g) == 0 {
+				delete(s.groups, group)
+			}
+		}
+	}
+	delete(s.socketGroups, so)
+}
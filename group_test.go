@@ -0,0 +1,131 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package pakt_test
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/desertbit/pakt"
+	"github.com/desertbit/pakt/codec/json"
+	"github.com/desertbit/pakt/memory"
+	"github.com/stretchr/testify/require"
+)
+
+// countingCodec counts the encoded values.
+type countingCodec struct {
+	encodes *int64
+}
+
+func (c countingCodec) Encode(v interface{}) ([]byte, error) {
+	atomic.AddInt64(c.encodes, 1)
+	return json.Codec.Encode(v)
+}
+
+func (c countingCodec) Decode(b []byte, v interface{}) error {
+	return json.Codec.Decode(b, v)
+}
+
+func TestServerGroups(t *testing.T) {
+	server, ln := memory.NewServer()
+	defer server.Close()
+	go server.Listen()
+
+	var encodes int64
+	c := countingCodec{encodes: &encodes}
+
+	sockets := make(chan *pakt.Socket, 3)
+	server.OnNewSocket(func(s *pakt.Socket) {
+		s.Codec = c
+		s.Ready()
+		sockets <- s
+	})
+
+	var received int64
+	clients := make([]*pakt.Socket, 3)
+	for i := range clients {
+		cl, err := memory.NewClient(ln)
+		require.NoError(t, err)
+		defer cl.Close()
+
+		cl.Codec = json.Codec
+		cl.RegisterFunc("event", func(ctx *pakt.Context) (interface{}, error) {
+			var s string
+			err := ctx.Decode(&s)
+			if err != nil {
+				return nil, err
+			} else if s != "hello" {
+				return nil, errors.New("invalid data")
+			}
+			atomic.AddInt64(&received, 1)
+			return nil, nil
+		})
+		cl.Ready()
+		clients[i] = cl
+	}
+
+	var ss []*pakt.Socket
+	for range clients {
+		s := <-sockets
+		ss = append(ss, s)
+		server.AddToGroup("room", s)
+	}
+	server.AddToGroup("other", ss[0])
+
+	groups := server.Groups()
+	sort.Strings(groups)
+	require.Equal(t, []string{"other", "room"}, groups)
+	require.Len(t, server.GroupSockets("room"), 3)
+
+	require.NoError(t, server.Broadcast("room", "event", "hello"))
+	require.Equal(t, int64(3), atomic.LoadInt64(&received))
+
+	// The data is encoded once for all sockets. The headers are
+	// encoded without the codec as soon as the hello was received.
+	atomic.StoreInt64(&encodes, 0)
+	require.NoError(t, server.Broadcast("room", "event", "hello"))
+	require.Equal(t, int64(6), atomic.LoadInt64(&received))
+	require.Equal(t, int64(1), atomic.LoadInt64(&encodes))
+
+	// Empty groups are no error.
+	require.NoError(t, server.Broadcast("empty", "event", "hello"))
+
+	// Errors are reported per socket.
+	server.RemoveFromGroup("room", ss[2])
+	require.Len(t, server.GroupSockets("room"), 2)
+
+	err := server.Broadcast("room", "event", "invalid", time.Second)
+	require.Error(t, err)
+	berr, ok := err.(*pakt.BroadcastError)
+	require.True(t, ok)
+	require.Len(t, berr.Errors, 2)
+	require.EqualError(t, berr.Errors[ss[0].ID()], "invalid data")
+	require.EqualError(t, berr.Errors[ss[1].ID()], "invalid data")
+
+	// Closed sockets are removed from all groups.
+	require.NoError(t, ss[0].Close())
+	require.Eventually(t, func() bool {
+		return len(server.GroupSockets("room")) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Empty(t, server.GroupSockets("other"))
+	require.Equal(t, []string{"room"}, server.Groups())
+}
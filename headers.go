@@ -15,19 +15,272 @@
  *  You should have received a copy of the GNU General Public License
  *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
  */
-
 package pakt
 
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+var (
+	errInvalidReturnKey = errors.New("invalid return key")
+	errInvalidHeader    = errors.New("invalid header")
+)
+
 //###############//
 //### Private ###//
 //###############//
 
-type headerCall struct {
+// callHeader is the header of a call message.
+type callHeader struct {
+	FuncID    string
+	ReturnKey uint64
+
+	// ReturnKeyV0 holds the raw return key of version 0 headers.
+	// Peers of version 0 use random string keys, which must be returned as is.
+	// ReturnKey is set if the key is numeric.
+	ReturnKeyV0      string
+	NumericReturnKey bool
+}
+
+// callReturnHeader is the header of a call return message.
+type callReturnHeader struct {
+	ReturnKey   uint64
+	ReturnKeyV0 string
+	ReturnErr   string
+	ErrCode     uint64
+
+	// Fields holds the field problems of validation errors.
+	Fields []FieldError
+}
+
+// Error codes of call return headers.
+// Version 0 headers only transmit the error message.
+const (
+	errCodeNone             uint64 = 0
+	errCodePermissionDenied uint64 = 1
+	errCodeValidation       uint64 = 2
+)
+
+// Version 0 headers are encoded with the codec.
+type headerCallV0 struct {
 	FuncID    string
 	ReturnKey string
 }
 
-type headerCallReturn struct {
+type headerCallReturnV0 struct {
 	ReturnKey string
 	ReturnErr string
 }
+
+// Version 1 headers are binary and independent of the codec:
+//   Call:       uvarint ReturnKey, uvarint FuncIndex, uvarint length, FuncID
+//   CallReturn: uvarint ReturnKey, uvarint length, ReturnErr, uvarint ErrCode,
+//               [uvarint count, count * (uvarint length, Field, uvarint length, Message)]
+// The function ID is empty if the call refers to an interned function index
+// defined by a previous call. The field problems are present if the error
+// code marks a validation error. Trailing bytes are ignored.
+
+// returnKeyV0 returns the version 0 representation of the return key.
+func returnKeyV0(key uint64, raw string) string {
+	if len(raw) > 0 {
+		return raw
+	}
+	return strconv.FormatUint(key, 10)
+}
+
+// supportsLegacyHeaders returns true if the codec is able to encode
+// version 0 headers. Codecs like protobuf only encode their own messages.
+func (s *Socket) supportsLegacyHeaders() bool {
+	s.legacyHeadersOnce.Do(func() {
+		_, err := s.Codec.Encode(&headerCallV0{})
+		s.legacyHeaders = err == nil
+	})
+	return s.legacyHeaders
+}
+
+// replyVersion returns the version used to return the call.
+// Peers supporting the binary headers send their hello before any call,
+// so the negotiated version is used. Legacy peers are answered with
+// the version of the call.
+func (s *Socket) replyVersion(version byte, h *callHeader) byte {
+	v := s.sendVersion()
+	if v == 0 || !h.NumericReturnKey {
+		return version
+	}
+	return v
+}
+
+func (s *Socket) encodeCallHeader(version byte, h *callHeader) ([]byte, error) {
+	if version == 0 {
+		return s.Codec.Encode(&headerCallV0{
+			FuncID:    h.FuncID,
+			ReturnKey: returnKeyV0(h.ReturnKey, h.ReturnKeyV0),
+		})
+	}
+
+	b := make([]byte, 0, 3*binary.MaxVarintLen64+len(h.FuncID))
+	b = binary.AppendUvarint(b, h.ReturnKey)
+	b = binary.AppendUvarint(b, 0)
+	b = appendString(b, h.FuncID)
+	return b, nil
+}
+
+func (s *Socket) decodeCallHeader(version byte, b []byte) (h callHeader, err error) {
+	if version == 0 {
+		var v0 headerCallV0
+		err = s.Codec.Decode(b, &v0)
+		if err != nil {
+			return
+		}
+
+		h.FuncID = v0.FuncID
+		h.ReturnKeyV0 = v0.ReturnKey
+
+		// Keys of peers supporting the binary headers are numeric.
+		key, perr := strconv.ParseUint(v0.ReturnKey, 10, 64)
+		if perr == nil {
+			h.ReturnKey = key
+			h.NumericReturnKey = true
+		}
+		return
+	}
+
+	h.NumericReturnKey = true
+
+	h.ReturnKey, b, err = readUvarint(b)
+	if err != nil {
+		return
+	}
+
+	var funcIndex uint64
+	funcIndex, b, err = readUvarint(b)
+	if err != nil {
+		return
+	}
+
+	h.FuncID, _, err = readString(b)
+	if err != nil {
+		return
+	} else if funcIndex != 0 && len(h.FuncID) == 0 {
+		// The function ID was interned by a previous call.
+		h.FuncID, err = s.internedFuncID(funcIndex)
+	}
+	return
+}
+
+func (s *Socket) encodeCallReturnHeader(version byte, h *callReturnHeader) ([]byte, error) {
+	if version == 0 {
+		return s.Codec.Encode(&headerCallReturnV0{
+			ReturnKey: returnKeyV0(h.ReturnKey, h.ReturnKeyV0),
+			ReturnErr: h.ReturnErr,
+		})
+	}
+
+	b := make([]byte, 0, 3*binary.MaxVarintLen64+len(h.ReturnErr))
+	b = binary.AppendUvarint(b, h.ReturnKey)
+	b = appendString(b, h.ReturnErr)
+	b = binary.AppendUvarint(b, h.ErrCode)
+	if h.ErrCode != errCodeValidation {
+		return b, nil
+	}
+
+	// Drop the field problems exceeding the maximum header size.
+	// The error message still describes all of them.
+	n, size := 0, len(b)+binary.MaxVarintLen64
+	for _, f := range h.Fields {
+		size += 2*binary.MaxVarintLen64 + len(f.Field) + len(f.Message)
+		if size > maxHeaderBufferSize {
+			break
+		}
+		n++
+	}
+
+	b = binary.AppendUvarint(b, uint64(n))
+	for _, f := range h.Fields[:n] {
+		b = appendString(b, f.Field)
+		b = appendString(b, f.Message)
+	}
+	return b, nil
+}
+
+func (s *Socket) decodeCallReturnHeader(version byte, b []byte) (h callReturnHeader, err error) {
+	if version == 0 {
+		var v0 headerCallReturnV0
+		err = s.Codec.Decode(b, &v0)
+		if err != nil {
+			return
+		}
+
+		// Returns for our calls hold the numeric key.
+		h.ReturnKey, err = strconv.ParseUint(v0.ReturnKey, 10, 64)
+		if err != nil {
+			return h, errInvalidReturnKey
+		}
+		h.ReturnErr = v0.ReturnErr
+		return
+	}
+
+	h.ReturnKey, b, err = readUvarint(b)
+	if err != nil {
+		return
+	}
+
+	h.ReturnErr, b, err = readString(b)
+	if err != nil || len(b) == 0 {
+		return
+	}
+
+	h.ErrCode, b, err = readUvarint(b)
+	if err != nil || h.ErrCode != errCodeValidation {
+		return
+	}
+
+	var n uint64
+	n, b, err = readUvarint(b)
+	if err != nil {
+		return
+	} else if n > uint64(len(b)/2) {
+		return h, errInvalidHeader
+	}
+
+	h.Fields = make([]FieldError, n)
+	for i := range h.Fields {
+		h.Fields[i].Field, b, err = readString(b)
+		if err != nil {
+			return
+		}
+		h.Fields[i].Message, b, err = readString(b)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// appendString appends the string prefixed with its length as uvarint.
+func appendString(b []byte, str string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(str)))
+	return append(b, str...)
+}
+
+// readUvarint returns the value and the remaining bytes.
+func readUvarint(b []byte) (uint64, []byte, error) {
+	v, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, nil, errInvalidHeader
+	}
+	return v, b[n:], nil
+}
+
+// readString returns the length prefixed string and the remaining bytes.
+func readString(b []byte) (string, []byte, error) {
+	l, b, err := readUvarint(b)
+	if err != nil {
+		return "", nil, err
+	} else if l > uint64(len(b)) {
+		return "", nil, errInvalidHeader
+	}
+	return string(b[:l]), b[l:], nil
+}
@@ -0,0 +1,284 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package pakt
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// legacyPeer speaks protocol version 0 without a hello message.
+type legacyPeer struct {
+	t    *testing.T
+	conn net.Conn
+	s    *Socket
+}
+
+func (p *legacyPeer) writeFrame(reqType byte, header, payload interface{}) {
+	var h, d []byte
+	var err error
+	if header != nil {
+		h, err = p.s.Codec.Encode(header)
+		require.NoError(p.t, err)
+	}
+	if payload != nil {
+		d, err = p.s.Codec.Encode(payload)
+		require.NoError(p.t, err)
+	}
+
+	head := make([]byte, 8)
+	head[1] = reqType
+	endian.PutUint16(head[2:4], uint16(len(h)))
+	endian.PutUint32(head[4:8], uint32(len(d)))
+
+	_, err = p.conn.Write(append(append(head, h...), d...))
+	require.NoError(p.t, err)
+}
+
+// readFrame returns the next frame, which is neither a hello nor a ping.
+// Pings are answered like by a version 0 socket.
+func (p *legacyPeer) readFrame() (version, reqType byte, header, payload []byte) {
+	for {
+		head := make([]byte, 8)
+		_, err := io.ReadFull(p.conn, head)
+		require.NoError(p.t, err)
+
+		header = make([]byte, endian.Uint16(head[2:4]))
+		_, err = io.ReadFull(p.conn, header)
+		require.NoError(p.t, err)
+
+		payload = make([]byte, endian.Uint32(head[4:8]))
+		_, err = io.ReadFull(p.conn, payload)
+		require.NoError(p.t, err)
+
+		switch head[1] {
+		case typeHello:
+		case typePing:
+			p.writeFrame(typePong, nil, nil)
+		default:
+			return head[0], head[1], header, payload
+		}
+	}
+}
+
+func TestLegacyPeer(t *testing.T) {
+	a, b := net.Pipe()
+	s := NewSocket(a)
+	defer s.Close()
+
+	p := &legacyPeer{t: t, conn: b, s: s}
+	defer b.Close()
+
+	s.RegisterFunc("echo", func(c *Context) (interface{}, error) {
+		var str string
+		err := c.Decode(&str)
+		return str, err
+	})
+	s.Ready()
+
+	// Calls of the legacy peer are returned with the string key.
+	p.writeFrame(typeCall, &headerCallV0{FuncID: "echo", ReturnKey: "aBcDeFgHiJ"}, "hello")
+
+	version, reqType, header, payload := p.readFrame()
+	require.Equal(t, byte(0), version)
+	require.Equal(t, typeCallReturn, reqType)
+
+	var ret headerCallReturnV0
+	require.NoError(t, s.Codec.Decode(header, &ret))
+	require.Equal(t, "aBcDeFgHiJ", ret.ReturnKey)
+
+	var str string
+	require.NoError(t, s.Codec.Decode(payload, &str))
+	require.Equal(t, "hello", str)
+
+	// Calls to the legacy peer use the numeric key as string.
+	go func() {
+		version, reqType, header, _ := p.readFrame()
+		if version != 0 || reqType != typeCall {
+			t.Errorf("invalid call frame: version=%v type=%v", version, reqType)
+			return
+		}
+
+		var call headerCallV0
+		if err := s.Codec.Decode(header, &call); err != nil {
+			t.Error(err)
+			return
+		}
+
+		p.writeFrame(typeCallReturn, &headerCallReturnV0{ReturnKey: call.ReturnKey}, call.FuncID)
+	}()
+
+	c, err := s.Call("legacy", nil, time.Second)
+	require.NoError(t, err)
+	require.NoError(t, c.Decode(&str))
+	require.Equal(t, "legacy", str)
+	require.Equal(t, byte(0), s.sendVersion())
+}
+
+func TestLegacyPeerRaw(t *testing.T) {
+	a, b := net.Pipe()
+	s := NewSocket(a)
+	defer s.Close()
+
+	p := &legacyPeer{t: t, conn: b, s: s}
+	defer b.Close()
+
+	s.Ready()
+
+	// The legacy peer answers the ping probing for its hello.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.readFrame()
+	}()
+
+	// Raw payloads fail as soon as the peer is identified.
+	start := time.Now()
+	_, err := s.Call("upload", Raw("data"), time.Second)
+	require.Equal(t, ErrRawNotSupported, err)
+	require.True(t, time.Since(start) < writeTimeout/2)
+
+	// Stop the legacy peer.
+	s.Close()
+	<-done
+}
+
+func TestVersionNegotiation(t *testing.T) {
+	a, b := net.Pipe()
+	sa := NewSocket(a)
+	sb := NewSocket(b)
+	defer sa.Close()
+
+	sb.RegisterFunc("echo", func(c *Context) (interface{}, error) {
+		var str string
+		err := c.Decode(&str)
+		return str, err
+	})
+
+	sa.Ready()
+	sb.Ready()
+
+	for i := 0; i < 3; i++ {
+		c, err := sa.Call("echo", "hello")
+		require.NoError(t, err)
+
+		var str string
+		require.NoError(t, c.Decode(&str))
+		require.Equal(t, "hello", str)
+	}
+
+	require.Equal(t, ProtocolVersion, sa.sendVersion())
+	require.Equal(t, ProtocolVersion, sb.sendVersion())
+}
+
+func TestHeaderEncoding(t *testing.T) {
+	s := NewSocket(nil)
+
+	for version := byte(0); version <= ProtocolVersion; version++ {
+		b, err := s.encodeCallHeader(version, &callHeader{FuncID: "func", ReturnKey: 1 << 40})
+		require.NoError(t, err)
+
+		h, err := s.decodeCallHeader(version, b)
+		require.NoError(t, err)
+		require.Equal(t, "func", h.FuncID)
+		if version == 0 {
+			require.Equal(t, "1099511627776", h.ReturnKeyV0)
+		} else {
+			require.Equal(t, uint64(1<<40), h.ReturnKey)
+		}
+
+		b, err = s.encodeCallReturnHeader(version, &callReturnHeader{ReturnKey: 1 << 40, ReturnErr: "err"})
+		require.NoError(t, err)
+
+		r, err := s.decodeCallReturnHeader(version, b)
+		require.NoError(t, err)
+		require.Equal(t, uint64(1<<40), r.ReturnKey)
+		require.Equal(t, "err", r.ReturnErr)
+
+		// Error codes are only transmitted by binary headers.
+		b, err = s.encodeCallReturnHeader(version, &callReturnHeader{ReturnKey: 1, ReturnErr: "err", ErrCode: errCodePermissionDenied})
+		require.NoError(t, err)
+
+		r, err = s.decodeCallReturnHeader(version, b)
+		require.NoError(t, err)
+		if version == 0 {
+			require.Equal(t, errCodeNone, r.ErrCode)
+		} else {
+			require.Equal(t, errCodePermissionDenied, r.ErrCode)
+		}
+	}
+
+	// Field problems of validation errors are part of binary headers.
+	fields := []FieldError{{Field: "Name", Message: "required"}, {Message: "invalid"}}
+	b, err := s.encodeCallReturnHeader(1, &callReturnHeader{ReturnKey: 1, ErrCode: errCodeValidation, Fields: fields})
+	require.NoError(t, err)
+
+	r, err := s.decodeCallReturnHeader(1, b)
+	require.NoError(t, err)
+	require.Equal(t, fields, r.Fields)
+
+	_, err = s.decodeCallReturnHeader(1, b[:len(b)-1])
+	require.Equal(t, errInvalidHeader, err)
+
+	// Field problems exceeding the maximum header size are dropped.
+	fields = make([]FieldError, maxHeaderBufferSize/10)
+	for i := range fields {
+		fields[i] = FieldError{Field: "Field", Message: "required"}
+	}
+	b, err = s.encodeCallReturnHeader(1, &callReturnHeader{ReturnKey: 1, ErrCode: errCodeValidation, Fields: fields})
+	require.NoError(t, err)
+	require.True(t, len(b) <= maxHeaderBufferSize)
+
+	r, err = s.decodeCallReturnHeader(1, b)
+	require.NoError(t, err)
+	require.NotEmpty(t, r.Fields)
+	require.True(t, len(r.Fields) < len(fields))
+
+	_, err = s.decodeCallReturnHeader(0, mustEncode(t, s, &headerCallReturnV0{ReturnKey: "aBcDeFgHiJ"}))
+	require.Equal(t, errInvalidReturnKey, err)
+}
+
+func mustEncode(t *testing.T, s *Socket, v interface{}) []byte {
+	b, err := s.Codec.Encode(v)
+	require.NoError(t, err)
+	return b
+}
+
+func TestHeaderDecodingInvalid(t *testing.T) {
+	s := NewSocket(nil)
+
+	b, err := s.encodeCallHeader(1, &callHeader{FuncID: "func", ReturnKey: 300})
+	require.NoError(t, err)
+
+	// Truncated headers are rejected.
+	for i := 0; i < len(b); i++ {
+		_, err = s.decodeCallHeader(1, b[:i])
+		require.Error(t, err, i)
+	}
+
+	// Function indexes must be negotiated.
+	_, err = s.decodeCallHeader(1, []byte{1, 1, 0})
+	require.Error(t, err)
+
+	_, err = s.decodeCallReturnHeader(1, []byte{1, 5, 'e'})
+	require.Equal(t, errInvalidHeader, err)
+}
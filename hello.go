@@ -0,0 +1,263 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package pakt
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/desertbit/pakt/compress"
+)
+
+const (
+	featureCompressPrefix = "compress/"
+
+	maxFeatureLength = 255
+)
+
+// SetCompression enables payload compression. The compressors are listed by
+// preference. The first compressor also supported by the peer is used to
+// compress payloads. Payloads smaller than the threshold in bytes are sent
+// uncompressed. Pass a negative threshold to use DefaultCompressionThreshold.
+// Peers without compression support still interoperate with uncompressed payloads.
+// The negotiation is only authenticated if encryption is enabled. Otherwise
+// a man-in-the-middle is able to disable compression by modifying the hello.
+// Only set this during initialization before calling Ready.
+func (s *Socket) SetCompression(threshold int, compressors ...compress.Compressor) {
+	if threshold < 0 {
+		threshold = DefaultCompressionThreshold
+	}
+
+	s.compressionThreshold = threshold
+	s.compressors = compressors
+}
+
+// PeerCompressor returns the name of the compressor used to compress
+// the payloads sent to the peer. Each peer chooses its own compressor.
+// Returns an empty string if payloads are not compressed.
+// This method is thread-safe.
+func (s *Socket) PeerCompressor() string {
+	p := s.getPeer()
+	if p == nil || p.SendCompressor == nil {
+		return ""
+	}
+	return p.SendCompressor.Name()
+}
+
+//###############//
+//### Private ###//
+//###############//
+
+// peerInfo holds the features negotiated with the peer.
+type peerInfo struct {
+	Version         byte
+	Compressors     []string
+	Nonce           []byte
+	FuncInternLimit int
+	Raw             bool
+
+	// Hello holds the received hello payload.
+	Hello []byte
+
+	// SendCompressor is used to compress the payloads sent to the peer.
+	// The index refers to the compressors of this socket.
+	SendCompressor      compress.Compressor
+	SendCompressorIndex byte
+}
+
+// sendVersion returns the protocol version used to send messages to the peer.
+// Version 0 is used until the hello of the peer was received.
+func (s *Socket) sendVersion() byte {
+	p := s.getPeer()
+	if p == nil {
+		return 0
+	} else if p.Version < ProtocolVersion {
+		return p.Version
+	}
+	return ProtocolVersion
+}
+
+// helloTranscript returns the sent hello payload and the peer.
+// Must only be called after the hello of the peer was received.
+func (s *Socket) helloTranscript() (sent []byte, p *peerInfo) {
+	s.peerMutex.RLock()
+	sent, p = s.sentHello, s.peer
+	s.peerMutex.RUnlock()
+	return
+}
+
+// getPeer returns nil if no hello was received from the peer.
+func (s *Socket) getPeer() (p *peerInfo) {
+	s.peerMutex.RLock()
+	p = s.peer
+	s.peerMutex.RUnlock()
+	return
+}
+
+func (s *Socket) writeHello() error {
+	features := make([]string, 0, len(s.compressors)+1)
+	for _, c := range s.compressors {
+		features = append(features, featureCompressPrefix+c.Name())
+	}
+	features = append(features, featureRaw)
+	if s.keyring != nil {
+		features = append(features, featureNoncePrefix+string(s.connNonce))
+	}
+	if s.funcInternLimit > 0 {
+		features = append(features, featureInternPrefix+strconv.Itoa(s.funcInternLimit))
+	}
+
+	// The hello payload holds the protocol version, followed
+	// by the length prefixed feature names.
+	payload := []byte{ProtocolVersion}
+	for _, f := range features {
+		if len(f) > maxFeatureLength {
+			return fmt.Errorf("feature name too long: %v", f)
+		}
+		payload = append(payload, byte(len(f)))
+		payload = append(payload, f...)
+	}
+
+	// Keep the payload to authenticate the hello messages.
+	s.peerMutex.Lock()
+	s.sentHello = payload
+	s.peerMutex.Unlock()
+
+	// Hello messages are always sent with version 0,
+	// so peers of all versions can read them.
+	return s.writeFrame(0, typeHello, nil, payload)
+}
+
+func (s *Socket) handleHello(payload []byte) error {
+	if len(payload) == 0 {
+		return errors.New("empty hello")
+	} else if s.getPeer() != nil {
+		return errors.New("duplicate hello")
+	}
+
+	p := &peerInfo{
+		Version: payload[0],
+		Hello:   append([]byte(nil), payload...),
+	}
+
+	// Parse the features.
+	payload = payload[1:]
+	for len(payload) > 0 {
+		l := int(payload[0])
+		if len(payload) < l+1 {
+			return errors.New("invalid feature length")
+		}
+
+		f := string(payload[1 : l+1])
+		payload = payload[l+1:]
+
+		if f == featureRaw {
+			p.Raw = true
+		} else if strings.HasPrefix(f, featureCompressPrefix) {
+			p.Compressors = append(p.Compressors, strings.TrimPrefix(f, featureCompressPrefix))
+		} else if strings.HasPrefix(f, featureNoncePrefix) {
+			p.Nonce = []byte(strings.TrimPrefix(f, featureNoncePrefix))
+		} else if strings.HasPrefix(f, featureInternPrefix) {
+			limit, err := strconv.Atoi(strings.TrimPrefix(f, featureInternPrefix))
+			if err != nil || limit < 0 {
+				return fmt.Errorf("invalid intern limit: %v", f)
+			}
+			p.FuncInternLimit = limit
+		}
+	}
+
+	if s.keyring != nil {
+		err := s.checkPeerNonce(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Choose our first compressor which is supported by the peer.
+Loop:
+	for i, c := range s.compressors {
+		for _, name := range p.Compressors {
+			if c.Name() == name {
+				p.SendCompressor = c
+				p.SendCompressorIndex = byte(i)
+				break Loop
+			}
+		}
+	}
+
+	s.peerMutex.Lock()
+	s.peer = p
+	s.peerMutex.Unlock()
+
+	close(s.peerHelloChan)
+
+	return nil
+}
+
+// compressPayload returns the compressed payload prefixed with the
+// compressor index, if compression was negotiated and worthwhile.
+func (s *Socket) compressPayload(payload []byte) ([]byte, bool, error) {
+	if len(payload) == 0 || len(payload) < s.compressionThreshold {
+		return payload, false, nil
+	}
+
+	p := s.getPeer()
+	if p == nil || p.SendCompressor == nil {
+		return payload, false, nil
+	}
+
+	compressed, err := p.SendCompressor.Compress(payload)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Only send the compressed payload if it is smaller.
+	if len(compressed)+1 >= len(payload) {
+		return payload, false, nil
+	}
+
+	buf := make([]byte, len(compressed)+1)
+	buf[0] = p.SendCompressorIndex
+	copy(buf[1:], compressed)
+
+	return buf, true, nil
+}
+
+func (s *Socket) decompressPayload(payload []byte) ([]byte, error) {
+	if len(payload) == 0 {
+		return nil, errors.New("empty compressed payload")
+	}
+
+	// The first byte is the index of the compressor announced by the peer.
+	p := s.getPeer()
+	if p == nil || int(payload[0]) >= len(p.Compressors) {
+		return nil, fmt.Errorf("invalid compressor index: %v", payload[0])
+	}
+	name := p.Compressors[payload[0]]
+
+	for _, c := range s.compressors {
+		if c.Name() == name {
+			return c.Decompress(payload[1:], s.maxMessageSize)
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported compressor: %v", name)
+}
@@ -0,0 +1,150 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package pakt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+const (
+	// DefaultFuncInternLimit specifies the default number of function IDs,
+	// which the peer may intern.
+	DefaultFuncInternLimit = 256
+
+	featureInternPrefix = "intern/"
+)
+
+// SetFuncInterning sets the maximum number of function IDs, which the peer
+// may map to small integer indexes. The first call of a function sends the
+// function ID with its index. Subsequent calls only send the index.
+// Interning is used if negotiated by both peers. Pass 0 to disable interning.
+// Only set this during initialization before calling Ready.
+func (s *Socket) SetFuncInterning(limit int) {
+	if limit < 0 {
+		limit = 0
+	}
+	s.funcInternLimit = limit
+}
+
+//###############//
+//### Private ###//
+//###############//
+
+type internState struct {
+	funcInternLimit int
+
+	// Function indexes of the calls sent to the peer.
+	// Guarded by the write mutex.
+	sendFuncIndexes map[string]uint64
+
+	// Function IDs interned by the peer. Only modified by the read loop.
+	recvFuncIDsMutex sync.RWMutex
+	recvFuncIDs      []string
+}
+
+// encodeInternedCallHeader encodes the call header and interns the function ID
+// if negotiated. It must be called by the writer in the order the frames
+// are written, because the first written call defines the index.
+// Must be called with the write mutex locked.
+func (s *Socket) encodeInternedCallHeader(version byte, h *callHeader) ([]byte, error) {
+	// Empty function IDs refer to defined indexes and are never interned.
+	p := s.getPeer()
+	if version == 0 || s.funcInternLimit == 0 || p == nil || p.FuncInternLimit == 0 || len(h.FuncID) == 0 {
+		return s.encodeCallHeader(version, h)
+	}
+
+	if s.sendFuncIndexes == nil {
+		s.sendFuncIndexes = make(map[string]uint64)
+	}
+
+	index, ok := s.sendFuncIndexes[h.FuncID]
+	define := false
+	if !ok {
+		// Don't intern more function IDs than allowed by the peer.
+		if len(s.sendFuncIndexes) >= p.FuncInternLimit {
+			return s.encodeCallHeader(version, h)
+		}
+
+		index = uint64(len(s.sendFuncIndexes) + 1)
+		s.sendFuncIndexes[h.FuncID] = index
+		define = true
+	}
+
+	// Calls of defined indexes send an empty function ID.
+	var funcID string
+	if define {
+		funcID = h.FuncID
+	}
+
+	b := make([]byte, 0, 3*binary.MaxVarintLen64+len(funcID))
+	b = binary.AppendUvarint(b, h.ReturnKey)
+	b = binary.AppendUvarint(b, index)
+	b = appendString(b, funcID)
+	return b, nil
+}
+
+// registerInternedFunc registers the function ID, if defined by the call header.
+// Must only be called by the read loop in the order of the received frames.
+func (s *Socket) registerInternedFunc(b []byte) error {
+	_, b, err := readUvarint(b)
+	if err != nil {
+		return err
+	}
+
+	index, b, err := readUvarint(b)
+	if err != nil {
+		return err
+	} else if index == 0 {
+		// Not interned.
+		return nil
+	}
+
+	funcID, _, err := readString(b)
+	if err != nil {
+		return err
+	} else if len(funcID) == 0 {
+		// No definition.
+		return nil
+	}
+
+	s.recvFuncIDsMutex.Lock()
+	defer s.recvFuncIDsMutex.Unlock()
+
+	// Indexes are assigned sequentially.
+	if index != uint64(len(s.recvFuncIDs)+1) {
+		return fmt.Errorf("invalid function index definition: %v", index)
+	} else if len(s.recvFuncIDs) >= s.funcInternLimit {
+		return fmt.Errorf("function intern limit exceeded")
+	}
+
+	s.recvFuncIDs = append(s.recvFuncIDs, funcID)
+	return nil
+}
+
+// internedFuncID returns the function ID of the index.
+func (s *Socket) internedFuncID(index uint64) (funcID string, err error) {
+	s.recvFuncIDsMutex.RLock()
+	defer s.recvFuncIDsMutex.RUnlock()
+
+	if index == 0 || index > uint64(len(s.recvFuncIDs)) {
+		return "", fmt.Errorf("unknown function index: %v", index)
+	}
+	return s.recvFuncIDs[index-1], nil
+}
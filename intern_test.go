@@ -0,0 +1,151 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package pakt
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newInternPair(t *testing.T, limitA, limitB int) (*Socket, *Socket) {
+	a, b := net.Pipe()
+	sa := NewSocket(a)
+	sb := NewSocket(b)
+
+	sa.SetFuncInterning(limitA)
+	sb.SetFuncInterning(limitB)
+
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("inventory.warehouse.func%v", i)
+		sb.RegisterFunc(id, func(c *Context) (interface{}, error) {
+			return id, nil
+		})
+	}
+
+	sa.Ready()
+	sb.Ready()
+
+	// Wait for the negotiation.
+	c, err := sa.Call("inventory.warehouse.func0")
+	require.NoError(t, err)
+
+	var id string
+	require.NoError(t, c.Decode(&id))
+	require.Equal(t, "inventory.warehouse.func0", id)
+
+	return sa, sb
+}
+
+func callInterned(t *testing.T, s *Socket, n int) {
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			funcID := fmt.Sprintf("inventory.warehouse.func%v", i%n)
+			c, err := s.Call(funcID)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			var id string
+			if err = c.Decode(&id); err != nil {
+				t.Error(err)
+			} else if id != funcID {
+				t.Errorf("invalid function called: %v != %v", id, funcID)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestFuncInterning(t *testing.T) {
+	sa, sb := newInternPair(t, DefaultFuncInternLimit, DefaultFuncInternLimit)
+	defer sa.Close()
+
+	callInterned(t, sa, 3)
+
+	sa.writeMutex.Lock()
+	require.Len(t, sa.sendFuncIndexes, 3)
+	sa.writeMutex.Unlock()
+
+	sb.recvFuncIDsMutex.RLock()
+	require.Len(t, sb.recvFuncIDs, 3)
+	sb.recvFuncIDsMutex.RUnlock()
+
+	// Interned calls only send the index with an empty function ID.
+	b, err := sa.encodeInternedCallHeader(ProtocolVersion, &callHeader{FuncID: "inventory.warehouse.func1", ReturnKey: 1})
+	require.NoError(t, err)
+	require.Len(t, b, 3)
+}
+
+func TestFuncInterningLimit(t *testing.T) {
+	sa, sb := newInternPair(t, DefaultFuncInternLimit, 1)
+	defer sa.Close()
+
+	// Function IDs exceeding the limit are sent as string.
+	callInterned(t, sa, 3)
+
+	sb.recvFuncIDsMutex.RLock()
+	require.Len(t, sb.recvFuncIDs, 1)
+	sb.recvFuncIDsMutex.RUnlock()
+}
+
+func TestFuncInterningDisabled(t *testing.T) {
+	sa, sb := newInternPair(t, 0, DefaultFuncInternLimit)
+	defer sa.Close()
+
+	callInterned(t, sa, 3)
+
+	sb.recvFuncIDsMutex.RLock()
+	require.Empty(t, sb.recvFuncIDs)
+	sb.recvFuncIDsMutex.RUnlock()
+}
+
+func TestFuncInterningInvalid(t *testing.T) {
+	s := NewSocket(nil)
+	s.SetFuncInterning(1)
+
+	// Indexes must be defined sequentially.
+	require.Error(t, s.registerInternedFunc([]byte{1, 2, 1, 'a'}))
+	require.NoError(t, s.registerInternedFunc([]byte{1, 1, 1, 'a'}))
+	require.Error(t, s.registerInternedFunc([]byte{1, 2, 1, 'b'}))
+
+	_, err := s.decodeCallHeader(ProtocolVersion, []byte{1, 2, 0})
+	require.Error(t, err)
+
+	h, err := s.decodeCallHeader(ProtocolVersion, []byte{1, 1, 0})
+	require.NoError(t, err)
+	require.Equal(t, "a", h.FuncID)
+
+	// Trailing header bytes are ignored.
+	require.NoError(t, s.registerInternedFunc([]byte{1, 1, 0, 'x'}))
+	h, err = s.decodeCallHeader(ProtocolVersion, []byte{1, 1, 0, 'x'})
+	require.NoError(t, err)
+	require.Equal(t, "a", h.FuncID)
+
+	h, err = s.decodeCallHeader(ProtocolVersion, []byte{1, 0, 1, 'b', 'x'})
+	require.NoError(t, err)
+	require.Equal(t, "b", h.FuncID)
+}
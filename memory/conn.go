@@ -0,0 +1,308 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memory
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Options defines the simulated link properties of in-memory connections.
+// The zero value defines an ideal link without delays.
+type Options struct {
+	// Latency delays the delivery of written data to the peer.
+	Latency time.Duration
+
+	// Bandwidth limits the transfer rate in bytes per second
+	// per direction. Writes block until the data is transferred.
+	// Zero means unlimited.
+	Bandwidth int
+}
+
+// Pipe creates a pair of connected in-memory connections.
+// Unlike net.Pipe, writes are buffered and don't wait for the peer to read.
+// One variadic argument specifies the simulated link options.
+func Pipe(opts ...Options) (net.Conn, net.Conn) {
+	var o Options
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+
+	a := newBuffer()
+	b := newBuffer()
+
+	return newConn(a, b, o, "a", "b"), newConn(b, a, o, "b", "a")
+}
+
+//###############//
+//### Private ###//
+//###############//
+
+type addr string
+
+func (a addr) Network() string { return "memory" }
+func (a addr) String() string  { return string(a) }
+
+type chunk struct {
+	data      []byte
+	deliverAt time.Time
+}
+
+// buffer holds the data of one direction of a pipe.
+type buffer struct {
+	mutex    sync.Mutex
+	chunks   []chunk
+	closed   bool
+	sentAt   time.Time
+	notifyCh chan struct{}
+}
+
+func newBuffer() *buffer {
+	return &buffer{
+		notifyCh: make(chan struct{}, 1),
+	}
+}
+
+// notify wakes a blocked reader.
+func (b *buffer) notify() {
+	select {
+	case b.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (b *buffer) close() {
+	b.mutex.Lock()
+	b.closed = true
+	b.mutex.Unlock()
+	b.notify()
+}
+
+type conn struct {
+	rb      *buffer
+	wb      *buffer
+	opts    Options
+	local   addr
+	remote  addr
+	closeCh chan struct{}
+
+	closeOnce sync.Once
+
+	deadlineMutex sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func newConn(rb, wb *buffer, opts Options, local, remote addr) *conn {
+	return &conn{
+		rb:      rb,
+		wb:      wb,
+		opts:    opts,
+		local:   local,
+		remote:  remote,
+		closeCh: make(chan struct{}),
+	}
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	for {
+		if c.isClosed() {
+			return 0, io.ErrClosedPipe
+		}
+
+		var wait time.Duration
+
+		c.rb.mutex.Lock()
+		if len(c.rb.chunks) > 0 {
+			ch := &c.rb.chunks[0]
+			wait = time.Until(ch.deliverAt)
+			if wait <= 0 {
+				n := copy(p, ch.data)
+				ch.data = ch.data[n:]
+				if len(ch.data) == 0 {
+					c.rb.chunks[0] = chunk{}
+					c.rb.chunks = c.rb.chunks[1:]
+				}
+				c.rb.mutex.Unlock()
+				return n, nil
+			}
+		} else if c.rb.closed {
+			c.rb.mutex.Unlock()
+			return 0, io.EOF
+		}
+		c.rb.mutex.Unlock()
+
+		// Wait for new data, the delivery of pending data,
+		// the deadline or the connection to close.
+		err := c.wait(c.rb.notifyCh, wait, c.getDeadline(true))
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	if c.isClosed() {
+		return 0, io.ErrClosedPipe
+	}
+
+	// Calculate the transfer time if the bandwidth is limited.
+	now := time.Now()
+
+	c.wb.mutex.Lock()
+	if c.wb.closed {
+		c.wb.mutex.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+
+	sentAt := now
+	if c.opts.Bandwidth > 0 {
+		if c.wb.sentAt.After(sentAt) {
+			sentAt = c.wb.sentAt
+		}
+		sentAt = sentAt.Add(time.Duration(len(p)) * time.Second / time.Duration(c.opts.Bandwidth))
+		c.wb.sentAt = sentAt
+	}
+
+	// Copy the data, because the caller may reuse the slice.
+	data := make([]byte, len(p))
+	copy(data, p)
+
+	c.wb.chunks = append(c.wb.chunks, chunk{
+		data:      data,
+		deliverAt: sentAt.Add(c.opts.Latency),
+	})
+	c.wb.mutex.Unlock()
+	c.wb.notify()
+
+	// Block until the data is transferred.
+	if wait := sentAt.Sub(now); wait > 0 {
+		err := c.wait(nil, wait, c.getDeadline(false))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// wait blocks until the notify channel triggers, the wait duration
+// elapsed (if positive), the deadline exceeds (if set) or the connection closes.
+func (c *conn) wait(notifyCh chan struct{}, wait time.Duration, deadline time.Time) error {
+	var waitC, deadlineC <-chan time.Time
+
+	if wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		waitC = t.C
+	}
+
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+
+		t := time.NewTimer(d)
+		defer t.Stop()
+		deadlineC = t.C
+	}
+
+	select {
+	case <-notifyCh:
+	case <-waitC:
+	case <-deadlineC:
+		return os.ErrDeadlineExceeded
+	case <-c.closeCh:
+		return io.ErrClosedPipe
+	}
+
+	return nil
+}
+
+func (c *conn) isClosed() bool {
+	select {
+	case <-c.closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *conn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+
+		// The peer reads the pending data followed by EOF
+		// and fails to write.
+		c.wb.close()
+		c.rb.close()
+	})
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *conn) getDeadline(read bool) (t time.Time) {
+	c.deadlineMutex.Lock()
+	if read {
+		t = c.readDeadline
+	} else {
+		t = c.writeDeadline
+	}
+	c.deadlineMutex.Unlock()
+	return
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.deadlineMutex.Lock()
+	c.readDeadline = t
+	c.writeDeadline = t
+	c.deadlineMutex.Unlock()
+
+	// Wake a blocked reader to apply the new deadline.
+	c.rb.notify()
+	return nil
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.deadlineMutex.Lock()
+	c.readDeadline = t
+	c.deadlineMutex.Unlock()
+
+	// Wake a blocked reader to apply the new deadline.
+	c.rb.notify()
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	c.deadlineMutex.Lock()
+	c.writeDeadline = t
+	c.deadlineMutex.Unlock()
+	return nil
+}
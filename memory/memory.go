@@ -0,0 +1,138 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package memory provides an in-memory PAKT transport to connect
+// sockets within the same process without any network.
+// It is useful for tests and in-process wiring.
+package memory
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/desertbit/pakt"
+)
+
+var (
+	// ErrListenerClosed defines the error if the listener is closed.
+	ErrListenerClosed = errors.New("memory listener closed")
+)
+
+// NewClient connects to the listener and returns a new PAKT socket.
+func NewClient(ln *Listener) (*pakt.Socket, error) {
+	// Connect to the server.
+	conn, err := ln.Dial()
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a new pakt socket.
+	s := pakt.NewSocket(conn)
+
+	return s, nil
+}
+
+// NewServer creates a new in-memory listener and returns a new PAKT server
+// using it. Pass the listener to NewClient to connect to the server.
+// One variadic argument specifies the simulated link options.
+func NewServer(opts ...Options) (*pakt.Server, *Listener) {
+	ln := NewListener(opts...)
+	return pakt.NewServer(ln), ln
+}
+
+// NewSocketPair returns two connected PAKT sockets.
+// One variadic argument specifies the simulated link options.
+func NewSocketPair(opts ...Options) (*pakt.Socket, *pakt.Socket) {
+	a, b := Pipe(opts...)
+	return pakt.NewSocket(a), pakt.NewSocket(b)
+}
+
+// Listener implements the net.Listener interface for in-memory connections.
+type Listener struct {
+	opts       Options
+	acceptChan chan net.Conn
+
+	closeMutex sync.Mutex
+	closeChan  chan struct{}
+}
+
+// NewListener creates a new in-memory listener.
+// One variadic argument specifies the simulated link options.
+func NewListener(opts ...Options) *Listener {
+	l := &Listener{
+		acceptChan: make(chan net.Conn),
+		closeChan:  make(chan struct{}),
+	}
+
+	if len(opts) > 0 {
+		l.opts = opts[0]
+	}
+
+	return l
+}
+
+// Dial connects to the listener.
+// This blocks until the connection is accepted.
+// Returns ErrListenerClosed if the listener is closed.
+func (l *Listener) Dial() (net.Conn, error) {
+	client, server := Pipe(l.opts)
+
+	select {
+	case <-l.closeChan:
+		return nil, ErrListenerClosed
+	case l.acceptChan <- server:
+		return client, nil
+	}
+}
+
+// Accept implements the net.Listener interface.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case <-l.closeChan:
+		return nil, ErrListenerClosed
+	case conn := <-l.acceptChan:
+		return conn, nil
+	}
+}
+
+// IsClosed returns a boolean indicating if the listener is closed.
+func (l *Listener) IsClosed() bool {
+	select {
+	case <-l.closeChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close implements the net.Listener interface.
+func (l *Listener) Close() error {
+	l.closeMutex.Lock()
+	defer l.closeMutex.Unlock()
+
+	if !l.IsClosed() {
+		close(l.closeChan)
+	}
+	return nil
+}
+
+// Addr implements the net.Listener interface.
+func (l *Listener) Addr() net.Addr {
+	return addr("memory")
+}
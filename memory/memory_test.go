@@ -0,0 +1,154 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memory_test
+
+import (
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/desertbit/pakt"
+	"github.com/desertbit/pakt/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	var wg sync.WaitGroup
+
+	server, ln := memory.NewServer()
+
+	server.OnNewSocket(func(s *pakt.Socket) {
+		s.RegisterFunc("echo", func(c *pakt.Context) (interface{}, error) {
+			var s string
+			err := c.Decode(&s)
+			return s, err
+		})
+		s.Ready()
+	})
+
+	go server.Listen()
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			c, err := memory.NewClient(ln)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer c.Close()
+
+			c.Ready()
+
+			for j := 0; j < 100; j++ {
+				ctx, err := c.Call("echo", "hello")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				var s string
+				err = ctx.Decode(&s)
+				if err != nil || s != "hello" {
+					t.Error("decode:", s, err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	server.Close()
+
+	_, err := memory.NewClient(ln)
+	require.Equal(t, memory.ErrListenerClosed, err)
+}
+
+func TestSocketPairLatency(t *testing.T) {
+	const latency = 50 * time.Millisecond
+
+	a, b := memory.NewSocketPair(memory.Options{Latency: latency})
+	defer a.Close()
+
+	b.RegisterFunc("ping", func(c *pakt.Context) (interface{}, error) {
+		return nil, nil
+	})
+
+	a.Ready()
+	b.Ready()
+
+	start := time.Now()
+	_, err := a.Call("ping")
+	require.NoError(t, err)
+	require.True(t, time.Since(start) >= 2*latency)
+
+	// The peer is closed too.
+	a.Close()
+	select {
+	case <-b.ClosedChan():
+	case <-time.After(time.Second):
+		t.Fatal("peer socket was not closed")
+	}
+}
+
+func TestPipe(t *testing.T) {
+	a, b := memory.Pipe(memory.Options{Bandwidth: 100 * 1024})
+
+	// Writes are buffered and throttled by the bandwidth.
+	start := time.Now()
+	n, err := a.Write(make([]byte, 10*1024))
+	require.NoError(t, err)
+	require.Equal(t, 10*1024, n)
+	require.True(t, time.Since(start) >= 90*time.Millisecond)
+
+	buf := make([]byte, 4*1024)
+	read := 0
+	for read < 10*1024 {
+		n, err = b.Read(buf)
+		require.NoError(t, err)
+		read += n
+	}
+
+	// Read deadlines.
+	b.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err = b.Read(buf)
+	require.Equal(t, os.ErrDeadlineExceeded, err)
+	b.SetReadDeadline(time.Time{})
+
+	// Pending data is read before EOF.
+	_, err = a.Write([]byte("bye"))
+	require.NoError(t, err)
+	require.NoError(t, a.Close())
+
+	n, err = b.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "bye", string(buf[:n]))
+
+	_, err = b.Read(buf)
+	require.Equal(t, io.EOF, err)
+
+	_, err = b.Write([]byte("x"))
+	require.Equal(t, io.ErrClosedPipe, err)
+}
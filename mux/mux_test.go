@@ -0,0 +1,232 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mux_test
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/desertbit/pakt"
+	"github.com/desertbit/pakt/memory"
+	"github.com/desertbit/pakt/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSockets(t *testing.T) {
+	a, b := memory.Pipe()
+
+	clientSession := mux.Client(a)
+	server := mux.NewServer(mux.Server(b))
+	defer server.Close()
+
+	server.OnNewSocket(func(s *pakt.Socket) {
+		name := mux.StreamName(s)
+		s.RegisterFunc("name", func(c *pakt.Context) (interface{}, error) {
+			return name, nil
+		})
+		s.Ready()
+	})
+
+	go server.Listen()
+
+	var sockets []*pakt.Socket
+	for _, name := range []string{"inventory", "billing", "events"} {
+		s, err := clientSession.OpenSocket(name)
+		require.NoError(t, err)
+		s.Ready()
+		sockets = append(sockets, s)
+	}
+
+	call := func(s *pakt.Socket) string {
+		c, err := s.Call("name")
+		require.NoError(t, err)
+
+		var name string
+		require.NoError(t, c.Decode(&name))
+		return name
+	}
+
+	require.Equal(t, "inventory", call(sockets[0]))
+	require.Equal(t, "billing", call(sockets[1]))
+	require.Equal(t, "events", call(sockets[2]))
+
+	// Closing a logical socket does not affect the others.
+	sockets[1].Close()
+	require.Equal(t, "inventory", call(sockets[0]))
+	require.Equal(t, "events", call(sockets[2]))
+
+	// Closing the session closes all sockets.
+	clientSession.Close()
+
+	for _, s := range []*pakt.Socket{sockets[0], sockets[2]} {
+		select {
+		case <-s.ClosedChan():
+		case <-time.After(3 * time.Second):
+			t.Fatal("socket was not closed")
+		}
+	}
+}
+
+func TestFlowControl(t *testing.T) {
+	a, b := memory.Pipe()
+
+	client := mux.Client(a)
+	defer client.Close()
+	server := mux.Server(b)
+	defer server.Close()
+
+	bulk, err := client.Open("bulk")
+	require.NoError(t, err)
+	rpc, err := client.Open("rpc")
+	require.NoError(t, err)
+
+	bulkPeer, err := server.AcceptStream()
+	require.NoError(t, err)
+	require.Equal(t, "bulk", bulkPeer.Name())
+	rpcPeer, err := server.AcceptStream()
+	require.NoError(t, err)
+	require.Equal(t, "rpc", rpcPeer.Name())
+
+	// The bulk stream blocks as soon as the window is used up,
+	// because the peer does not read.
+	data := make([]byte, 2*mux.DefaultWindowSize)
+	writeDone := make(chan error, 1)
+	go func() {
+		_, err := bulk.Write(data)
+		writeDone <- err
+	}()
+
+	select {
+	case <-writeDone:
+		t.Fatal("write did not block on a full window")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Other streams are not affected.
+	_, err = rpc.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(rpcPeer, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+
+	// Reading the bulk data releases the writer.
+	received := make([]byte, len(data))
+	_, err = io.ReadFull(bulkPeer, received)
+	require.NoError(t, err)
+	require.NoError(t, <-writeDone)
+
+	// The peer reads EOF after close.
+	require.NoError(t, bulk.Close())
+	_, err = bulkPeer.Read(buf)
+	require.Equal(t, io.EOF, err)
+
+	_, err = bulkPeer.Write(buf)
+	require.Equal(t, mux.ErrStreamClosed, err)
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	a, b := memory.Pipe()
+
+	client := mux.Client(a)
+	defer client.Close()
+	server := mux.Server(b)
+	defer server.Close()
+
+	st, err := client.Open("stream")
+	require.NoError(t, err)
+	peer, err := server.AcceptStream()
+	require.NoError(t, err)
+
+	require.NoError(t, st.Close())
+	_, err = st.Write([]byte("data"))
+	require.Equal(t, mux.ErrStreamClosed, err)
+
+	_, err = peer.Read(make([]byte, 4))
+	require.Equal(t, io.EOF, err)
+}
+
+func TestReusedStreamID(t *testing.T) {
+	a, b := memory.Pipe()
+	defer a.Close()
+
+	server := mux.Server(b)
+	defer server.Close()
+
+	// Drain the frames written by the session.
+	go io.Copy(io.Discard, a)
+
+	writeFrame := func(frameType byte, id uint32, payload string) {
+		head := make([]byte, 10)
+		head[1] = frameType
+		binary.BigEndian.PutUint32(head[2:6], id)
+		binary.BigEndian.PutUint32(head[6:10], uint32(len(payload)))
+		_, err := a.Write(append(head, payload...))
+		require.NoError(t, err)
+	}
+
+	// Open and close the stream.
+	writeFrame(0, 1, "stream")
+	st, err := server.AcceptStream()
+	require.NoError(t, err)
+	writeFrame(3, 1, "")
+	require.NoError(t, st.Close())
+
+	// The ID of the removed stream is rejected.
+	writeFrame(0, 1, "stream")
+	select {
+	case <-server.ClosedChan():
+	case <-time.After(time.Second):
+		t.Fatal("reused stream ID not rejected")
+	}
+}
+
+func TestSessionCloseStreams(t *testing.T) {
+	a, b := memory.Pipe()
+
+	client := mux.Client(a)
+	server := mux.Server(b)
+	defer server.Close()
+
+	st, err := client.Open("stream")
+	require.NoError(t, err)
+	peer, err := server.AcceptStream()
+	require.NoError(t, err)
+	require.False(t, st.IsClosed())
+
+	// Closing the session closes all streams.
+	require.NoError(t, client.Close())
+	require.True(t, st.IsClosed())
+	require.NoError(t, st.Close())
+
+	_, err = st.Write([]byte("data"))
+	require.Equal(t, mux.ErrSessionClosed, err)
+	_, err = st.Read(make([]byte, 4))
+	require.Equal(t, io.EOF, err)
+
+	// The peer's streams are closed as soon as its session notices the close.
+	require.Eventually(t, peer.IsClosed, time.Second, 10*time.Millisecond)
+	require.True(t, server.IsClosed())
+
+	_, err = client.Open("stream")
+	require.Equal(t, mux.ErrSessionClosed, err)
+}
@@ -0,0 +1,443 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package mux multiplexes multiple logical PAKT sockets over one connection.
+// Each logical socket has its own function map, codec and close semantics.
+// Every stream has its own flow control window, so a busy stream
+// can't starve other streams. See PROTOCOL.md for the frame format.
+package mux
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/desertbit/pakt"
+)
+
+//#################//
+//### Constants ###//
+//#################//
+
+const (
+	// ProtocolVersion defines the multiplexing protocol version.
+	ProtocolVersion byte = 0
+
+	// DefaultWindowSize specifies the initial receive window size of a stream in bytes.
+	// Larger windows may be passed to Client and Server.
+	DefaultWindowSize = 256 * 1024
+)
+
+const (
+	frameHeadSize     = 10
+	maxFramePayload   = 16 * 1024
+	maxStreamNameSize = 1024
+	acceptBacklog     = 64
+)
+
+const (
+	typeOpen         byte = 0
+	typeData         byte = 1
+	typeWindowUpdate byte = 2
+	typeClose        byte = 3
+)
+
+//#################//
+//### Variables ###//
+//#################//
+
+var (
+	// ErrSessionClosed defines the error if the session is closed.
+	ErrSessionClosed = errors.New("mux session closed")
+
+	// ErrStreamClosed defines the error if the stream is closed.
+	ErrStreamClosed = errors.New("mux stream closed")
+
+	endian = binary.BigEndian
+)
+
+//####################//
+//### Session Type ###//
+//####################//
+
+// Session multiplexes streams over one connection.
+// It implements the net.Listener interface to accept streams
+// opened by the peer, so it can be passed to pakt.NewServer.
+type Session struct {
+	conn       net.Conn
+	windowSize uint32
+
+	nextIDMutex sync.Mutex
+	nextID      uint32
+
+	// peerNextID is the lowest ID the peer may open.
+	// Only accessed by the read loop.
+	peerNextID uint32
+
+	streamsMutex sync.Mutex
+	streams      map[uint32]*Stream
+
+	acceptChan chan *Stream
+
+	writeMutex sync.Mutex
+
+	closeMutex sync.Mutex
+	closeChan  chan struct{}
+}
+
+// Client creates a new session for the client side of the connection.
+// One variadic argument specifies the receive window size of the streams.
+// It is raised to DefaultWindowSize if smaller.
+func Client(conn net.Conn, windowSize ...int) *Session {
+	return newSession(conn, 1, windowSize...)
+}
+
+// Server creates a new session for the server side of the connection.
+// One variadic argument specifies the receive window size of the streams.
+// It is raised to DefaultWindowSize if smaller.
+func Server(conn net.Conn, windowSize ...int) *Session {
+	return newSession(conn, 2, windowSize...)
+}
+
+// NewServer returns a new PAKT server accepting
+// the logical sockets opened by the peer of the session.
+func NewServer(s *Session) *pakt.Server {
+	return pakt.NewServer(s)
+}
+
+// StreamName returns the name of the stream used by the socket.
+// Returns an empty string if the socket does not use a stream.
+func StreamName(s *pakt.Socket) string {
+	st, ok := s.Conn().(*Stream)
+	if !ok {
+		return ""
+	}
+	return st.Name()
+}
+
+// Open a new stream with the name. The name is passed to the peer.
+// This method is thread-safe.
+func (s *Session) Open(name string) (*Stream, error) {
+	if len(name) > maxStreamNameSize {
+		return nil, fmt.Errorf("stream name exceeds maximum size of %v bytes", maxStreamNameSize)
+	} else if s.IsClosed() {
+		return nil, ErrSessionClosed
+	}
+
+	s.nextIDMutex.Lock()
+	id := s.nextID
+	s.nextID += 2
+	s.nextIDMutex.Unlock()
+
+	st := newStream(s, id, name)
+
+	s.streamsMutex.Lock()
+	if s.IsClosed() {
+		s.streamsMutex.Unlock()
+		return nil, ErrSessionClosed
+	}
+	s.streams[id] = st
+	s.streamsMutex.Unlock()
+
+	err := s.writeFrame(typeOpen, id, []byte(name))
+	if err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+
+	err = st.sendInitialWindow()
+	if err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// OpenSocket opens a new stream with the name and returns a new PAKT socket using it.
+// This method is thread-safe.
+func (s *Session) OpenSocket(name string) (*pakt.Socket, error) {
+	st, err := s.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return pakt.NewSocket(st), nil
+}
+
+// AcceptStream waits for the next stream opened by the peer.
+// Returns ErrSessionClosed if the session is closed.
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case <-s.closeChan:
+		return nil, ErrSessionClosed
+	case st := <-s.acceptChan:
+		return st, nil
+	}
+}
+
+// Accept implements the net.Listener interface.
+func (s *Session) Accept() (net.Conn, error) {
+	st, err := s.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Addr implements the net.Listener interface.
+func (s *Session) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// IsClosed returns a boolean indicating if the session is closed.
+// This method is thread-safe.
+func (s *Session) IsClosed() bool {
+	select {
+	case <-s.closeChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// ClosedChan returns a channel which is closed as soon as the session is closed.
+// This method is thread-safe.
+func (s *Session) ClosedChan() pakt.ClosedChan {
+	return s.closeChan
+}
+
+// Close the session, the connection and all streams.
+// This method is thread-safe.
+func (s *Session) Close() error {
+	s.closeMutex.Lock()
+	if s.IsClosed() {
+		s.closeMutex.Unlock()
+		return nil
+	}
+	close(s.closeChan)
+	s.closeMutex.Unlock()
+
+	err := s.conn.Close()
+
+	// No streams are added after the close channel was closed.
+	// The peer notices the close with the connection.
+	s.streamsMutex.Lock()
+	streams := s.streams
+	s.streams = make(map[uint32]*Stream)
+	s.streamsMutex.Unlock()
+
+	for _, st := range streams {
+		st.closeLocal()
+	}
+
+	return err
+}
+
+//###############//
+//### Private ###//
+//###############//
+
+func newSession(conn net.Conn, firstID uint32, windowSize ...int) *Session {
+	s := &Session{
+		conn:       conn,
+		windowSize: DefaultWindowSize,
+		nextID:     firstID,
+		peerNextID: 3 - firstID,
+		streams:    make(map[uint32]*Stream),
+		acceptChan: make(chan *Stream, acceptBacklog),
+		closeChan:  make(chan struct{}),
+	}
+
+	if len(windowSize) > 0 && windowSize[0] > DefaultWindowSize {
+		s.windowSize = uint32(windowSize[0])
+	}
+
+	go s.readLoop()
+
+	return s
+}
+
+func (s *Session) getStream(id uint32) (st *Stream) {
+	s.streamsMutex.Lock()
+	st = s.streams[id]
+	s.streamsMutex.Unlock()
+	return
+}
+
+func (s *Session) removeStream(id uint32) {
+	s.streamsMutex.Lock()
+	delete(s.streams, id)
+	s.streamsMutex.Unlock()
+}
+
+func (s *Session) writeFrame(frameType byte, id uint32, payload []byte) error {
+	head := make([]byte, frameHeadSize)
+	head[0] = ProtocolVersion
+	head[1] = frameType
+	endian.PutUint32(head[2:6], id)
+	endian.PutUint32(head[6:10], uint32(len(payload)))
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	if s.IsClosed() {
+		return ErrSessionClosed
+	}
+
+	_, err := s.conn.Write(head)
+	if err == nil && len(payload) > 0 {
+		_, err = s.conn.Write(payload)
+	}
+	if err != nil {
+		s.Close()
+		return err
+	}
+
+	return nil
+}
+
+func (s *Session) readLoop() {
+	// Close the session on exit.
+	defer s.Close()
+
+	head := make([]byte, frameHeadSize)
+
+	for {
+		_, err := io.ReadFull(s.conn, head)
+		if err != nil {
+			if err != io.EOF && !s.IsClosed() {
+				pakt.Log.Warningf("mux: read: %v", err)
+			}
+			return
+		}
+
+		if head[0] != ProtocolVersion {
+			pakt.Log.Warningf("mux: read: invalid protocol version: %v != %v", ProtocolVersion, head[0])
+			return
+		}
+
+		frameType := head[1]
+		id := endian.Uint32(head[2:6])
+		length := endian.Uint32(head[6:10])
+
+		if length > maxFramePayload {
+			pakt.Log.Warningf("mux: read: maximum frame size exceeded")
+			return
+		}
+
+		var payload []byte
+		if length > 0 {
+			payload = make([]byte, length)
+			_, err = io.ReadFull(s.conn, payload)
+			if err != nil {
+				if !s.IsClosed() {
+					pakt.Log.Warningf("mux: read: %v", err)
+				}
+				return
+			}
+		}
+
+		err = s.handleFrame(frameType, id, payload)
+		if err != nil {
+			pakt.Log.Warningf("mux: %v", err)
+			return
+		}
+	}
+}
+
+func (s *Session) handleFrame(frameType byte, id uint32, payload []byte) error {
+	switch frameType {
+	case typeOpen:
+		return s.handleOpen(id, payload)
+
+	case typeData:
+		st := s.getStream(id)
+		if st == nil {
+			// The stream was closed locally. Drop the data.
+			return nil
+		}
+		return st.pushData(payload)
+
+	case typeWindowUpdate:
+		if len(payload) != 4 {
+			return fmt.Errorf("invalid window update frame")
+		}
+
+		st := s.getStream(id)
+		if st != nil {
+			st.addSendWindow(endian.Uint32(payload))
+		}
+
+	case typeClose:
+		st := s.getStream(id)
+		if st != nil {
+			st.remoteClose()
+		}
+
+	default:
+		return fmt.Errorf("invalid frame type: %v", frameType)
+	}
+
+	return nil
+}
+
+func (s *Session) handleOpen(id uint32, payload []byte) error {
+	// Streams opened by the peer must use the peer's ID parity.
+	// IDs increase, so IDs of removed streams are never used again.
+	if id%2 != s.peerNextID%2 {
+		return fmt.Errorf("invalid stream ID opened by peer: %v", id)
+	} else if id < s.peerNextID {
+		return fmt.Errorf("stream ID already used by peer: %v", id)
+	}
+	s.peerNextID = id + 2
+
+	st := newStream(s, id, string(payload))
+
+	s.streamsMutex.Lock()
+	_, exists := s.streams[id]
+	closed := s.IsClosed()
+	if !exists && !closed {
+		s.streams[id] = st
+	}
+	s.streamsMutex.Unlock()
+
+	if exists {
+		return fmt.Errorf("stream already exists: %v", id)
+	} else if closed {
+		return nil
+	}
+
+	err := st.sendInitialWindow()
+	if err != nil {
+		return err
+	}
+
+	// Reject the stream if the backlog is full.
+	// Never block the read loop.
+	select {
+	case s.acceptChan <- st:
+	default:
+		pakt.Log.Warningf("mux: accept backlog full: rejecting stream %v", id)
+		st.Close()
+	}
+
+	return nil
+}
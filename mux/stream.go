@@ -0,0 +1,376 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mux
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+//###################//
+//### Stream Type ###//
+//###################//
+
+// Stream defines a logical connection within a session.
+// It implements the net.Conn interface and can be passed to pakt.NewSocket.
+type Stream struct {
+	id      uint32
+	name    string
+	session *Session
+
+	recvMutex    sync.Mutex
+	recvBuf      bytes.Buffer
+	recvWindow   int64
+	recvConsumed uint32
+	remoteClosed bool
+	recvNotify   chan struct{}
+
+	sendMutex  sync.Mutex
+	sendWindow int64
+	sendNotify chan struct{}
+
+	writeMutex sync.Mutex
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+
+	deadlineMutex sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// ID returns the stream ID.
+func (s *Stream) ID() uint32 {
+	return s.id
+}
+
+// Name returns the stream name passed to Session.Open.
+func (s *Stream) Name() string {
+	return s.name
+}
+
+// Read implements the net.Conn interface.
+// Returns io.EOF if the peer closed the stream and all data was read.
+func (s *Stream) Read(b []byte) (int, error) {
+	for {
+		s.recvMutex.Lock()
+		if s.recvBuf.Len() > 0 {
+			n, _ := s.recvBuf.Read(b)
+
+			// Acknowledge the consumed data as soon as half of the window is used up.
+			var update uint32
+			s.recvConsumed += uint32(n)
+			if s.recvConsumed >= s.session.windowSize/2 {
+				update = s.recvConsumed
+				s.recvConsumed = 0
+				s.recvWindow += int64(update)
+			}
+			s.recvMutex.Unlock()
+
+			if update > 0 {
+				s.sendWindowUpdate(update)
+			}
+
+			return n, nil
+		} else if s.remoteClosed {
+			s.recvMutex.Unlock()
+			return 0, io.EOF
+		}
+		s.recvMutex.Unlock()
+
+		err := s.wait(s.recvNotify, s.getDeadline(true))
+		if err == ErrSessionClosed {
+			return 0, io.EOF
+		} else if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write implements the net.Conn interface.
+// Blocks until the peer has enough window space.
+// Returns ErrStreamClosed if the stream was closed.
+func (s *Stream) Write(b []byte) (int, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	total := 0
+	for len(b) > 0 {
+		n := len(b)
+		if n > maxFramePayload {
+			n = maxFramePayload
+		}
+
+		n, err := s.reserveSendWindow(n)
+		if err != nil {
+			return total, err
+		}
+
+		err = s.session.writeFrame(typeData, s.id, b[:n])
+		if err != nil {
+			return total, err
+		}
+
+		b = b[n:]
+		total += n
+	}
+
+	return total, nil
+}
+
+// IsClosed returns a boolean indicating if the stream is closed.
+// Streams are closed with their session.
+// This method is thread-safe.
+func (s *Stream) IsClosed() bool {
+	select {
+	case <-s.closeChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close the stream. The peer reads the pending data followed by io.EOF.
+// This method is thread-safe.
+func (s *Stream) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closeChan)
+
+		// Wait for pending writes, so no data is sent after the close frame.
+		s.writeMutex.Lock()
+		defer s.writeMutex.Unlock()
+
+		s.session.removeStream(s.id)
+		err = s.session.writeFrame(typeClose, s.id, nil)
+	})
+	if err == ErrSessionClosed {
+		err = nil
+	}
+	return err
+}
+
+// LocalAddr implements the net.Conn interface.
+func (s *Stream) LocalAddr() net.Addr {
+	return s.session.conn.LocalAddr()
+}
+
+// RemoteAddr implements the net.Conn interface.
+func (s *Stream) RemoteAddr() net.Addr {
+	return s.session.conn.RemoteAddr()
+}
+
+// SetDeadline implements the net.Conn interface.
+func (s *Stream) SetDeadline(t time.Time) error {
+	s.deadlineMutex.Lock()
+	s.readDeadline = t
+	s.writeDeadline = t
+	s.deadlineMutex.Unlock()
+
+	notify(s.recvNotify)
+	notify(s.sendNotify)
+	return nil
+}
+
+// SetReadDeadline implements the net.Conn interface.
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.deadlineMutex.Lock()
+	s.readDeadline = t
+	s.deadlineMutex.Unlock()
+
+	notify(s.recvNotify)
+	return nil
+}
+
+// SetWriteDeadline implements the net.Conn interface.
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.deadlineMutex.Lock()
+	s.writeDeadline = t
+	s.deadlineMutex.Unlock()
+
+	notify(s.sendNotify)
+	return nil
+}
+
+//###############//
+//### Private ###//
+//###############//
+
+func newStream(session *Session, id uint32, name string) *Stream {
+	return &Stream{
+		id:         id,
+		name:       name,
+		session:    session,
+		recvWindow: int64(session.windowSize),
+		sendWindow: DefaultWindowSize,
+		recvNotify: make(chan struct{}, 1),
+		sendNotify: make(chan struct{}, 1),
+		closeChan:  make(chan struct{}),
+	}
+}
+
+// notify wakes a blocked reader or writer.
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// sendInitialWindow raises the peer's send window if the
+// local window is larger than the default window.
+func (s *Stream) sendInitialWindow() error {
+	if s.session.windowSize <= DefaultWindowSize {
+		return nil
+	}
+
+	payload := make([]byte, 4)
+	endian.PutUint32(payload, s.session.windowSize-DefaultWindowSize)
+	return s.session.writeFrame(typeWindowUpdate, s.id, payload)
+}
+
+func (s *Stream) sendWindowUpdate(update uint32) {
+	payload := make([]byte, 4)
+	endian.PutUint32(payload, update)
+
+	// Ignore errors. The session closes on write errors.
+	_ = s.session.writeFrame(typeWindowUpdate, s.id, payload)
+}
+
+// pushData is called by the session read loop and must never block.
+func (s *Stream) pushData(data []byte) error {
+	s.recvMutex.Lock()
+	s.recvWindow -= int64(len(data))
+	if s.recvWindow < 0 {
+		s.recvMutex.Unlock()
+		return fmt.Errorf("stream %v: receive window exceeded by peer", s.id)
+	}
+	s.recvBuf.Write(data)
+	s.recvMutex.Unlock()
+
+	notify(s.recvNotify)
+	return nil
+}
+
+// closeLocal closes the stream without notifying the peer.
+// It is called if the session closes.
+func (s *Stream) closeLocal() {
+	s.closeOnce.Do(func() {
+		close(s.closeChan)
+	})
+}
+
+func (s *Stream) remoteClose() {
+	s.recvMutex.Lock()
+	s.remoteClosed = true
+	s.recvMutex.Unlock()
+
+	notify(s.recvNotify)
+	notify(s.sendNotify)
+}
+
+func (s *Stream) addSendWindow(update uint32) {
+	s.sendMutex.Lock()
+	s.sendWindow += int64(update)
+	s.sendMutex.Unlock()
+
+	notify(s.sendNotify)
+}
+
+// reserveSendWindow waits until window space is available and reserves
+// up to n bytes. Returns the number of reserved bytes.
+func (s *Stream) reserveSendWindow(n int) (int, error) {
+	for {
+		if s.session.IsClosed() {
+			return 0, ErrSessionClosed
+		} else if s.IsClosed() {
+			return 0, ErrStreamClosed
+		}
+
+		s.recvMutex.Lock()
+		remoteClosed := s.remoteClosed
+		s.recvMutex.Unlock()
+		if remoteClosed {
+			return 0, ErrStreamClosed
+		}
+
+		s.sendMutex.Lock()
+		if s.sendWindow > 0 {
+			if int64(n) > s.sendWindow {
+				n = int(s.sendWindow)
+			}
+			s.sendWindow -= int64(n)
+			s.sendMutex.Unlock()
+			return n, nil
+		}
+		s.sendMutex.Unlock()
+
+		err := s.wait(s.sendNotify, s.getDeadline(false))
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// wait blocks until the notify channel triggers, the deadline
+// exceeds (if set) or the stream or session closes.
+func (s *Stream) wait(notifyChan chan struct{}, deadline time.Time) error {
+	var deadlineC <-chan time.Time
+
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+
+		t := time.NewTimer(d)
+		defer t.Stop()
+		deadlineC = t.C
+	}
+
+	select {
+	case <-notifyChan:
+		return nil
+	case <-deadlineC:
+		return os.ErrDeadlineExceeded
+	case <-s.closeChan:
+		if s.session.IsClosed() {
+			return ErrSessionClosed
+		}
+		return ErrStreamClosed
+	case <-s.session.closeChan:
+		return ErrSessionClosed
+	}
+}
+
+func (s *Stream) getDeadline(read bool) (t time.Time) {
+	s.deadlineMutex.Lock()
+	if read {
+		t = s.readDeadline
+	} else {
+		t = s.writeDeadline
+	}
+	s.deadlineMutex.Unlock()
+	return
+}
@@ -22,7 +22,8 @@
 package pakt
 
 import (
-	"bytes"
+	"crypto/tls"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -32,6 +33,7 @@ import (
 
 	"github.com/desertbit/pakt/codec"
 	"github.com/desertbit/pakt/codec/msgpack"
+	"github.com/desertbit/pakt/compress"
 )
 
 //#################//
@@ -39,14 +41,19 @@ import (
 //#################//
 
 const (
-	// ProtocolVersion defines the protocol version defined in the specifications.
-	ProtocolVersion byte = 0
+	// ProtocolVersion defines the highest protocol version defined in the specifications.
+	// The version used with a peer is negotiated with the hello messages.
+	ProtocolVersion byte = 1
 
 	// DefaultMaxMessageSize specifies the default maximum message payload size in KiloBytes.
 	DefaultMaxMessageSize = 100 * 1024
 
 	// DefaultCallTimeout specifies the default timeout for a call request.
 	DefaultCallTimeout = 30 * time.Second
+
+	// DefaultCompressionThreshold specifies the default payload size in bytes
+	// below which payloads are sent uncompressed.
+	DefaultCompressionThreshold = 1024
 )
 
 const (
@@ -64,6 +71,14 @@ const (
 	typePong       byte = 2
 	typeCall       byte = 3
 	typeCallReturn byte = 4
+	typeHello      byte = 5
+	typeHelloMAC   byte = 6
+
+	// The upper bits of the type field hold the frame flags.
+	typeMask       byte = 0x0F
+	flagCompressed byte = 0x80
+	flagEncrypted  byte = 0x40
+	flagRaw        byte = 0x20
 )
 
 //#################//
@@ -111,6 +126,7 @@ type Socket struct {
 	// Codec holds the encoding and decoding interface.
 	Codec codec.Codec
 
+	idMutex        sync.RWMutex
 	id             string
 	conn           net.Conn
 	writeMutex     sync.Mutex
@@ -125,9 +141,31 @@ type Socket struct {
 
 	funcMapMutex sync.RWMutex
 	funcMap      map[string]Func
+	validators   map[string]ValidateFunc
 
 	funcChain *chain
 
+	compressors          []compress.Compressor
+	compressionThreshold int
+
+	peerMutex      sync.RWMutex
+	peer           *peerInfo
+	peerHelloChan  chan struct{}
+	peerLegacyChan chan struct{}
+	peerProbeOnce  sync.Once
+	sentHello      []byte
+
+	legacyHeadersOnce sync.Once
+	legacyHeaders     bool
+
+	encryptionState
+	writerState
+	internState
+
+	principalMutex sync.RWMutex
+	principal      *Principal
+	accessPolicy   AccessPolicy
+
 	callHook  CallHook
 	errorHook ErrorHook
 }
@@ -147,6 +185,10 @@ func NewSocket(conn net.Conn, vars ...string) *Socket {
 		closeChan:            make(chan struct{}),
 		funcMap:              make(map[string]Func),
 		funcChain:            newChain(),
+		peerHelloChan:        make(chan struct{}),
+		peerLegacyChan:       make(chan struct{}),
+		writerState:          newWriterState(supportsWritev(conn)),
+		internState:          internState{funcInternLimit: DefaultFuncInternLimit},
 	}
 
 	// Set the ID if specified.
@@ -158,18 +200,28 @@ func NewSocket(conn net.Conn, vars ...string) *Socket {
 }
 
 // Ready signalizes the Socket that the initialization is done.
-// The socket starts reading from the underlying connection.
+// The socket negotiates the supported features with the peer
+// and starts reading from the underlying connection.
 // This should be only called once per socket.
 func (s *Socket) Ready() {
 	// Start the service routines.
+	s.closeMutex.Lock()
+	s.writerStarted = true
+	s.closeMutex.Unlock()
+
+	go s.writeLoop()
 	go s.readLoop()
 	go s.timeoutLoop()
 	go s.pingLoop()
 }
 
 // ID returns the socket ID.
-func (s *Socket) ID() string {
-	return s.id
+// This method is thread-safe.
+func (s *Socket) ID() (id string) {
+	s.idMutex.RLock()
+	id = s.id
+	s.idMutex.RUnlock()
+	return
 }
 
 // LocalAddr returns the local network address.
@@ -182,6 +234,25 @@ func (s *Socket) RemoteAddr() net.Addr {
 	return s.conn.RemoteAddr()
 }
 
+// Conn returns the underlying connection.
+// Don't read from or write to the connection directly.
+// Use it to obtain transport specific information about the peer.
+func (s *Socket) Conn() net.Conn {
+	return s.conn
+}
+
+// TLSConnectionState returns the state of the underlying TLS connection.
+// The boolean is false if the socket does not use a TLS connection.
+// The state is complete only after the handshake, which is performed before
+// sockets are passed by the server or returned by the tls client.
+func (s *Socket) TLSConnectionState() (tls.ConnectionState, bool) {
+	c, ok := s.conn.(*tls.Conn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return c.ConnectionState(), true
+}
+
 // SetMaxMessageSize sets the maximum message size in bytes.
 // Only set this during initialization.
 func (s *Socket) SetMaxMessageSize(size int) {
@@ -196,12 +267,39 @@ func (s *Socket) SetCallHook(h CallHook) {
 }
 
 // SetErrorHook sets the error hook function which is triggered, if a local
-// remote callable function returns an error. This hook can be used for logging purpose.
+// remote callable function returns an error or if a call is denied by the
+// access policy. This hook can be used for logging purpose.
 // Only set this hook during initialization.
 func (s *Socket) SetErrorHook(h ErrorHook) {
 	s.errorHook = h
 }
 
+// SetAccessPolicy sets the policy which decides if the remote peer is permitted
+// to call a local function. Denied calls return ErrPermissionDenied to the caller.
+// If no policy is set, all calls are permitted.
+// Only set this during initialization.
+func (s *Socket) SetAccessPolicy(p AccessPolicy) {
+	s.accessPolicy = p
+}
+
+// SetPrincipal sets the identity of the remote peer used by the access policy.
+// This method is thread-safe.
+func (s *Socket) SetPrincipal(p *Principal) {
+	s.principalMutex.Lock()
+	s.principal = p
+	s.principalMutex.Unlock()
+}
+
+// Principal returns the identity of the remote peer.
+// Returns nil if not set.
+// This method is thread-safe.
+func (s *Socket) Principal() (p *Principal) {
+	s.principalMutex.RLock()
+	p = s.principal
+	s.principalMutex.RUnlock()
+	return
+}
+
 // SetCallTimeout sets the timeout for call requests.
 // Only set this during initialization.
 func (s *Socket) SetCallTimeout(t time.Duration) {
@@ -244,13 +342,14 @@ func (s *Socket) Close() error {
 		s.closeMutex.Unlock()
 		return nil
 	}
+
+	// Write the pending messages and tell the other peer,
+	// that the connection was closed.
+	s.closeWriter()
+
 	close(s.closeChan)
 	s.closeMutex.Unlock()
 
-	// Tell the other peer, that the connection was closed.
-	// Ignore errors. The connection might be closed already.
-	_ = s.write(typeClose, nil, nil)
-
 	// Close the socket connection.
 	return s.conn.Close()
 }
@@ -263,6 +362,15 @@ func (s *Socket) RegisterFunc(id string, f Func) {
 	s.funcMapMutex.Unlock()
 }
 
+// UnregisterFunc removes the remote function.
+// Calls of the function fail afterwards.
+// This method is thread-safe.
+func (s *Socket) UnregisterFunc(id string) {
+	s.funcMapMutex.Lock()
+	delete(s.funcMap, id)
+	s.funcMapMutex.Unlock()
+}
+
 // RegisterFuncs registers a map of remote functions.
 // This method is thread-safe.
 func (s *Socket) RegisterFuncs(funcs Funcs) {
@@ -285,14 +393,11 @@ func (s *Socket) RegisterFuncs(funcs Funcs) {
 // This method is thread-safe.
 func (s *Socket) Call(id string, args ...interface{}) (*Context, error) {
 	// Create a new channel with its key.
-	key, channel, err := s.funcChain.New()
-	if err != nil {
-		return nil, err
-	}
+	key, channel := s.funcChain.New()
 	defer s.funcChain.Delete(key)
 
 	// Create the header.
-	header := &headerCall{
+	header := &callHeader{
 		FuncID:    id,
 		ReturnKey: key,
 	}
@@ -303,8 +408,18 @@ func (s *Socket) Call(id string, args ...interface{}) (*Context, error) {
 		data = args[0]
 	}
 
+	// Version 0 headers are encoded with the codec. Wait for the hello
+	// of the peer, if the codec is not able to encode them.
+	version := s.sendVersion()
+	if version == 0 && !s.supportsLegacyHeaders() {
+		if err := s.waitPeerHello(); err != nil {
+			return nil, err
+		}
+		version = s.sendVersion()
+	}
+
 	// Write to the client.
-	err = s.write(typeCall, header, data)
+	err := s.write(version, typeCall, header, data)
 	if err != nil {
 		return nil, err
 	}
@@ -352,13 +467,43 @@ type retChainData struct {
 	Err     error
 }
 
-func (s *Socket) write(reqType byte, headerI interface{}, dataI interface{}) (err error) {
-	var payload, header []byte
+// write encodes the header of the version and the data and queues the message.
+func (s *Socket) write(version, reqType byte, headerI interface{}, dataI interface{}) (err error) {
+	var header []byte
 
-	// Marshal the payload data if present.
-	if dataI != nil {
-		payload, err = s.Codec.Encode(dataI)
+	// Stream codecs and raw readers encode into a pooled buffer, which is
+	// released by the writer routine. Release it on errors, if it was not queued.
+	var payloadBufP *[]byte
+	defer func() {
 		if err != nil {
+			putBuffer(payloadBufP)
+		}
+	}()
+
+	// Raw payloads are sent without encoding.
+	payload, payloadBufP, raw, err := s.rawPayload(dataI)
+	if err != nil {
+		return err
+	} else if raw {
+		reqType |= flagRaw
+	}
+
+	// Marshal the payload data if present.
+	// Broadcast payloads are already encoded.
+	if e, ok := dataI.(encodedData); ok {
+		payload = e
+	} else if dataI != nil && !raw {
+		if sc, ok := s.Codec.(codec.StreamCodec); ok {
+			w := newBufferWriter(s.maxMessageSize)
+			payloadBufP = w.bp
+			err = sc.EncodeTo(w, dataI)
+			payload = *w.bp
+		} else {
+			payload, err = s.Codec.Encode(dataI)
+		}
+		if err == ErrMaxMsgSizeExceeded {
+			return err
+		} else if err != nil {
 			return fmt.Errorf("encode: %v", err)
 		}
 	}
@@ -368,18 +513,35 @@ func (s *Socket) write(reqType byte, headerI interface{}, dataI interface{}) (er
 		return ErrMaxMsgSizeExceeded
 	}
 
-	// Get the length of the payload data in bytes.
-	payloadLen, err := uint32ToBytes(uint32(len(payload)))
+	// Compress the payload if negotiated with the peer.
+	payload, compressed, err := s.compressPayload(payload)
 	if err != nil {
-		return err
+		return fmt.Errorf("compress: %v", err)
+	} else if compressed {
+		// The payload was compressed into a new buffer.
+		reqType |= flagCompressed
+		putBuffer(payloadBufP)
+		payloadBufP = nil
 	}
 
 	// Marshal the header data if present.
-	if headerI != nil {
-		header, err = s.Codec.Encode(headerI)
-		if err != nil {
-			return fmt.Errorf("encode header: %v", err)
+	var call *callHeader
+	switch h := headerI.(type) {
+	case *callHeader:
+		if version >= 1 {
+			// The header is encoded by the writer to intern the function ID.
+			call = h
+			if len(h.FuncID)+3*binary.MaxVarintLen64 > maxHeaderBufferSize {
+				return fmt.Errorf("maximum header size exceeded")
+			}
+		} else {
+			header, err = s.encodeCallHeader(version, h)
 		}
+	case *callReturnHeader:
+		header, err = s.encodeCallReturnHeader(version, h)
+	}
+	if err != nil {
+		return fmt.Errorf("encode header: %v", err)
 	}
 
 	// Check if the maximum header size is exceeded.
@@ -387,66 +549,15 @@ func (s *Socket) write(reqType byte, headerI interface{}, dataI interface{}) (er
 		return fmt.Errorf("maximum header size exceeded")
 	}
 
-	// Get the length of the header in bytes.
-	headerLen, err := uint16ToBytes(uint16(len(header)))
-	if err != nil {
-		return err
-	}
-
-	// TODO: Think about a buffer pool to release load on the GC.
-	// Fill our message buffer.
-	var buf bytes.Buffer
-	err = buf.WriteByte(ProtocolVersion)
-	if err != nil {
-		return err
-	}
-
-	err = buf.WriteByte(reqType)
-	if err != nil {
-		return err
-	}
-
-	_, err = buf.Write(headerLen)
-	if err != nil {
-		return err
-	}
-
-	_, err = buf.Write(payloadLen)
-	if err != nil {
-		return err
-	}
-
-	if len(header) > 0 {
-		_, err = buf.Write(header)
-		if err != nil {
-			return err
-		}
-	}
-
-	if len(payload) > 0 {
-		_, err = buf.Write(payload)
-		if err != nil {
-			return err
-		}
-	}
-
-	// Calculate the write deadline.
-	writeDeadline := time.Now().Add(writeTimeout)
-
-	// Lock the mutex.
-	s.writeMutex.Lock()
-	defer s.writeMutex.Unlock()
-
-	// Reset the read deadline.
-	s.conn.SetWriteDeadline(writeDeadline)
-
-	// Write the message bytes to the peer.
-	_, err = s.conn.Write(buf.Bytes())
-	if err != nil {
-		return err
-	}
-
-	return nil
+	// Queue the message for the writer routine.
+	return s.enqueue(sendFrame{
+		version: version,
+		reqType: reqType,
+		header:  header,
+		payload: payload,
+		pooled:  payloadBufP,
+		call:    call,
+	})
 }
 
 func (s *Socket) read(buf []byte) (int, error) {
@@ -474,7 +585,6 @@ func (s *Socket) readLoop() {
 	defer s.Close()
 
 	var err error
-	var n, bytesRead int
 
 	// Message Head.
 	headBuf := make([]byte, 8)
@@ -483,31 +593,30 @@ func (s *Socket) readLoop() {
 	var payloadLen32 uint32
 	var payloadLen int
 
+	// Peers sending a hello always send it as first message.
+	firstMessage := true
+
 	// Read loop.
 	for {
 		// Read the head from the stream.
-		bytesRead = 0
-		for bytesRead < 8 {
-			n, err = s.read(headBuf[bytesRead:])
-			if err != nil {
-				// Log only if not closed.
-				if err != io.EOF && !s.IsClosed() {
-					Log.Warningf("socket: read: %v", err)
-				}
-				return
-			}
-			bytesRead += n
+		err = s.readFull(headBuf)
+		if err != nil {
+			return
 		}
 
 		// The first byte is the version field.
 		// Check if this protocol version matches.
-		if headBuf[0] != ProtocolVersion {
-			Log.Warningf("socket: read: invalid protocol version: %v != %v", ProtocolVersion, headBuf[0])
+		// Frames of all versions up to this protocol version are accepted.
+		version := headBuf[0]
+		if version > ProtocolVersion {
+			Log.Warningf("socket: read: unsupported protocol version: %v > %v", version, ProtocolVersion)
 			return
 		}
 
 		// Extract the head fields.
-		reqType := headBuf[1]
+		// The upper bits of the type field hold the frame flags.
+		reqType := headBuf[1] & typeMask
+		flags := headBuf[1] &^ typeMask
 
 		// Extract the header length.
 		headerLen16, err = bytesToUint16(headBuf[2:4])
@@ -518,7 +627,7 @@ func (s *Socket) readLoop() {
 		headerLen = int(headerLen16)
 
 		// Check if the maximum header size is exceeded.
-		if headerLen > maxHeaderBufferSize {
+		if headerLen > maxHeaderBufferSize+encryptionInfoSize+gcmOverhead {
 			Log.Warningf("socket: read: maximum header size exceeded")
 			return
 		}
@@ -532,50 +641,116 @@ func (s *Socket) readLoop() {
 		payloadLen = int(payloadLen32)
 
 		// Check if the maximum payload size is exceeded.
-		if payloadLen > s.maxMessageSize {
+		if payloadLen > s.maxMessageSize+gcmOverhead {
 			Log.Warningf("socket: read: maximum message size exceeded")
 			return
 		}
 
-		// Read the header bytes from the stream.
+		// Read the header bytes from the stream into a pooled buffer.
+		// It is released as soon as the header is decoded.
+		var headerBufP *[]byte
 		var headerBuf []byte
 		if headerLen > 0 {
-			headerBuf = make([]byte, headerLen)
-			bytesRead = 0
-			for bytesRead < headerLen {
-				n, err = s.read(headerBuf[bytesRead:])
-				if err != nil {
-					// Log only if not closed.
-					if err != io.EOF && !s.IsClosed() {
-						Log.Warningf("socket: read: %v", err)
-					}
-					return
-				}
-				bytesRead += n
+			headerBufP = getBuffer(headerLen)
+			headerBuf = *headerBufP
+			err = s.readFull(headerBuf)
+			if err != nil {
+				return
 			}
 		}
 
-		// Read the payload bytes from the stream.
+		// Read the payload bytes from the stream. Compressed payloads are
+		// read into a pooled buffer, because they are decompressed into a
+		// new buffer. Other payloads are passed to the context.
+		var payloadBufP *[]byte
 		var payloadBuf []byte
 		if payloadLen > 0 {
-			payloadBuf = make([]byte, payloadLen)
-			bytesRead = 0
-			for bytesRead < payloadLen {
-				n, err = s.read(payloadBuf[bytesRead:])
+			if flags&flagCompressed != 0 {
+				payloadBufP = getBuffer(payloadLen)
+				payloadBuf = *payloadBufP
+			} else {
+				payloadBuf = make([]byte, payloadLen)
+			}
+			err = s.readFull(payloadBuf)
+			if err != nil {
+				return
+			}
+		}
+
+		// Peers of version 0 never send a hello. Identify them
+		// by their first message, so nobody waits for their hello.
+		if firstMessage {
+			firstMessage = false
+			if reqType != typeHello {
+				close(s.peerLegacyChan)
+			}
+		}
+
+		// Decrypt the message synchronously to verify the sequence numbers.
+		// Hello messages are never encrypted. All other messages must be
+		// encrypted if encryption is enabled.
+		encrypted := flags&flagEncrypted != 0
+		if s.keyring != nil && reqType != typeHello {
+			if !encrypted {
+				Log.Warningf("socket: read: rejected unencrypted message")
+				return
+			}
+
+			headerBuf, payloadBuf, err = s.decryptFrame(headBuf, headerBuf, payloadBuf)
+			if err != nil {
+				Log.Warningf("socket: read: %v", err)
+				return
+			}
+
+			// The first encrypted message authenticates the hello messages.
+			if reqType == typeHelloMAC {
+				err = s.verifyHelloMAC(payloadBuf)
+				putBuffer(headerBufP)
+				putBuffer(payloadBufP)
 				if err != nil {
-					// Log only if not closed.
-					if err != io.EOF && !s.IsClosed() {
-						Log.Warningf("socket: read: %v", err)
-					}
+					Log.Warningf("socket: read: %v", err)
 					return
 				}
-				bytesRead += n
+				continue
+			} else if !s.helloMACVerified {
+				Log.Warningf("socket: read: hello not authenticated")
+				return
+			}
+		} else if encrypted {
+			Log.Warningf("socket: read: encryption not enabled")
+			return
+		}
+
+		// Register interned function IDs synchronously, because
+		// the following calls may only refer to the index.
+		if reqType == typeCall && version >= 1 {
+			err = s.registerInternedFunc(headerBuf)
+			if err != nil {
+				Log.Warningf("socket: read: %v", err)
+				return
+			}
+		}
+
+		// Handle the hello message synchronously, because
+		// the following messages depend on the negotiation.
+		if reqType == typeHello {
+			err = s.handleHello(payloadBuf)
+			putBuffer(headerBufP)
+			putBuffer(payloadBufP)
+			if err != nil {
+				Log.Warningf("socket: hello: %v", err)
+				return
 			}
+			s.resetTimeout()
+			continue
 		}
 
 		// Handle the received message in a new goroutine.
 		go func() {
-			err := s.handleReceivedMessage(reqType, headerBuf, payloadBuf)
+			defer putBuffer(headerBufP)
+			defer putBuffer(payloadBufP)
+
+			err := s.handleReceivedMessage(version, reqType, flags, headerBuf, payloadBuf)
 			if err != nil {
 				Log.Warningf("socket: %v", err)
 			}
@@ -583,7 +758,25 @@ func (s *Socket) readLoop() {
 	}
 }
 
-func (s *Socket) handleReceivedMessage(reqType byte, headerBuf, payloadBuf []byte) (err error) {
+// readFull reads exactly len(buf) bytes from the connection.
+// Errors are logged if the socket is not closed.
+func (s *Socket) readFull(buf []byte) error {
+	var bytesRead int
+	for bytesRead < len(buf) {
+		n, err := s.read(buf[bytesRead:])
+		if err != nil {
+			// Log only if not closed.
+			if err != io.EOF && !s.IsClosed() {
+				Log.Warningf("socket: read: %v", err)
+			}
+			return err
+		}
+		bytesRead += n
+	}
+	return nil
+}
+
+func (s *Socket) handleReceivedMessage(version, reqType, flags byte, headerBuf, payloadBuf []byte) (err error) {
 	// Catch panics.
 	defer func() {
 		if e := recover(); e != nil {
@@ -594,6 +787,14 @@ func (s *Socket) handleReceivedMessage(reqType byte, headerBuf, payloadBuf []byt
 	// Reset the timeout, because data was successful read from the socket.
 	s.resetTimeout()
 
+	// Decompress the payload if compressed.
+	if flags&flagCompressed != 0 {
+		payloadBuf, err = s.decompressPayload(payloadBuf)
+		if err != nil {
+			return fmt.Errorf("decompress: %v", err)
+		}
+	}
+
 	// Check the request type.
 	switch reqType {
 	case typeClose:
@@ -602,7 +803,7 @@ func (s *Socket) handleReceivedMessage(reqType byte, headerBuf, payloadBuf []byt
 
 	case typePing:
 		// The socket peer has requested a pong response.
-		err = s.write(typePong, nil, nil)
+		err = s.write(s.sendVersion(), typePong, nil, nil)
 		if err != nil {
 			return fmt.Errorf("failed to send pong response: %v", err)
 		}
@@ -611,10 +812,10 @@ func (s *Socket) handleReceivedMessage(reqType byte, headerBuf, payloadBuf []byt
 		// Don't do anything. The socket timeouts have already been reset.
 
 	case typeCall:
-		return s.handleCallRequest(headerBuf, payloadBuf)
+		return s.handleCallRequest(version, flags&flagRaw != 0, headerBuf, payloadBuf)
 
 	case typeCallReturn:
-		return s.handleCallReturnRequest(headerBuf, payloadBuf)
+		return s.handleCallReturnRequest(version, flags&flagRaw != 0, headerBuf, payloadBuf)
 
 	default:
 		return fmt.Errorf("invalid request type: %v", reqType)
@@ -623,14 +824,19 @@ func (s *Socket) handleReceivedMessage(reqType byte, headerBuf, payloadBuf []byt
 	return nil
 }
 
-func (s *Socket) handleCallRequest(headerBuf, payloadBuf []byte) (err error) {
+func (s *Socket) handleCallRequest(version byte, raw bool, headerBuf, payloadBuf []byte) (err error) {
 	// Decode the header.
-	var header headerCall
-	err = s.Codec.Decode(headerBuf, &header)
+	header, err := s.decodeCallHeader(version, headerBuf)
 	if err != nil {
 		return fmt.Errorf("decode call header: %v", err)
 	}
 
+	// Check if the remote peer is permitted to call the function.
+	// This is done before the function lookup to not leak registered function IDs.
+	if s.accessPolicy != nil && !s.accessPolicy.Permitted(s, s.Principal(), header.FuncID) {
+		return s.handleCallRequestDenied(s.replyVersion(version, &header), &header)
+	}
+
 	// Obtain the function defined by the ID.
 	s.funcMapMutex.RLock()
 	f, ok := s.funcMap[header.FuncID]
@@ -640,7 +846,8 @@ func (s *Socket) handleCallRequest(headerBuf, payloadBuf []byte) (err error) {
 	}
 
 	// Create a new context.
-	context := newContext(s, payloadBuf)
+	context := newContext(s, payloadBuf, raw)
+	context.funcID = header.FuncID
 
 	// Call the call hook if defined.
 	if s.callHook != nil {
@@ -650,20 +857,26 @@ func (s *Socket) handleCallRequest(headerBuf, payloadBuf []byte) (err error) {
 	// Call the function.
 	retData, retErr := f(context)
 
-	// Get the string representation of the error if present.
-	var retErrString string
-	if retErr != nil {
-		retErrString = retErr.Error()
+	// Create the return header.
+	retHeader := &callReturnHeader{
+		ReturnKey:   header.ReturnKey,
+		ReturnKeyV0: header.ReturnKeyV0,
 	}
 
-	// Create the return header.
-	retHeader := &headerCallReturn{
-		ReturnKey: header.ReturnKey,
-		ReturnErr: retErrString,
+	// Set the string representation of the error if present.
+	// The field problems of validation errors are sent within the header.
+	if retErr != nil {
+		retHeader.ReturnErr = retErr.Error()
+
+		var verr *ValidationError
+		if errors.As(retErr, &verr) {
+			retHeader.ErrCode = errCodeValidation
+			retHeader.Fields = verr.Fields
+		}
 	}
 
 	// Write to the client.
-	err = s.write(typeCallReturn, retHeader, retData)
+	err = s.write(s.replyVersion(version, &header), typeCallReturn, retHeader, retData)
 	if err != nil {
 		return fmt.Errorf("call request: send return request: %v", err)
 	}
@@ -676,10 +889,32 @@ func (s *Socket) handleCallRequest(headerBuf, payloadBuf []byte) (err error) {
 	return nil
 }
 
-func (s *Socket) handleCallReturnRequest(headerBuf, payloadBuf []byte) (err error) {
+func (s *Socket) handleCallRequestDenied(version byte, header *callHeader) error {
+	// Create the return header.
+	retHeader := &callReturnHeader{
+		ReturnKey:   header.ReturnKey,
+		ReturnKeyV0: header.ReturnKeyV0,
+		ReturnErr:   ErrPermissionDenied.Error(),
+		ErrCode:     errCodePermissionDenied,
+	}
+
+	// Write to the client.
+	err := s.write(version, typeCallReturn, retHeader, nil)
+	if err != nil {
+		return fmt.Errorf("call request: send permission denied return request: %v", err)
+	}
+
+	// Call the error hook if defined.
+	if s.errorHook != nil {
+		s.errorHook(s, header.FuncID, ErrPermissionDenied)
+	}
+
+	return nil
+}
+
+func (s *Socket) handleCallReturnRequest(version byte, raw bool, headerBuf, payloadBuf []byte) (err error) {
 	// Decode the header.
-	var header headerCallReturn
-	err = s.Codec.Decode(headerBuf, &header)
+	header, err := s.decodeCallReturnHeader(version, headerBuf)
 	if err != nil {
 		return fmt.Errorf("decode call return header: %v", err)
 	}
@@ -690,15 +925,20 @@ func (s *Socket) handleCallReturnRequest(headerBuf, payloadBuf []byte) (err erro
 		return fmt.Errorf("call return request failed (call timeout exceeded?)")
 	}
 
+	// Create a new context.
+	context := newContext(s, payloadBuf, raw)
+
 	// Create the error if present.
 	var retErr error
-	if len(header.ReturnErr) > 0 {
+	switch {
+	case header.ErrCode == errCodePermissionDenied:
+		retErr = ErrPermissionDenied
+	case header.ErrCode == errCodeValidation:
+		retErr = &ValidationError{Fields: header.Fields}
+	case len(header.ReturnErr) > 0:
 		retErr = errors.New(header.ReturnErr)
 	}
 
-	// Create a new context.
-	context := newContext(s, payloadBuf)
-
 	// Create the channel data.
 	rData := retChainData{
 		Context: context,
@@ -72,7 +72,7 @@ func (s *Socket) pingLoop() {
 
 		case <-timer.C:
 			// Send a ping request to the socket peer.
-			err := s.write(typePing, nil, nil)
+			err := s.write(s.sendVersion(), typePing, nil, nil)
 			if err != nil {
 				Log.Warningf("socket: failed to send ping request: %v", err)
 			}
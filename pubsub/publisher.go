@@ -0,0 +1,357 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package pubsub implements publish/subscribe event channels on top of PAKT sockets.
+// A subscriber subscribes to topics of the peer socket. The publisher
+// of the server sends the published values to all subscribed sockets.
+//
+// Subscriptions and events are no protocol frames, but regular RPC calls
+// to reserved function IDs. Subscribers call "pakt.pubsub.subscribe" and
+// "pakt.pubsub.unsubscribe" of the publisher's socket and the publisher
+// calls "pakt.pubsub.event/<topic>" of the subscriber's socket. If sockets
+// use an access policy, it must permit these function IDs, e.g. with the
+// ACL rule "pakt.pubsub.*".
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/desertbit/pakt"
+)
+
+//#################//
+//### Constants ###//
+//#################//
+
+const (
+	funcIDPrefix      = "pakt.pubsub."
+	funcIDSubscribe   = funcIDPrefix + "subscribe"
+	funcIDUnsubscribe = funcIDPrefix + "unsubscribe"
+	funcIDEventPrefix = funcIDPrefix + "event/"
+
+	groupPrefix = "pubsub/"
+)
+
+//#################//
+//### Variables ###//
+//#################//
+
+var (
+	// ErrInvalidTopic defines the error if the topic is empty.
+	ErrInvalidTopic = errors.New("pubsub: invalid topic")
+)
+
+//######################//
+//### Publisher Type ###//
+//######################//
+
+// Publisher holds the topic registry of a server. Each topic is a group of
+// the server, so sockets are removed from all topics as soon as they close.
+type Publisher struct {
+	server *pakt.Server
+
+	replay       bool
+	eventTimeout time.Duration
+
+	topicsMutex sync.Mutex
+	topics      map[string]*topic
+}
+
+// NewPublisher creates a new publisher for the sockets of the server.
+// Pass each new socket to Serve before calling its Ready method.
+func NewPublisher(server *pakt.Server) *Publisher {
+	return &Publisher{
+		server: server,
+		topics: make(map[string]*topic),
+	}
+}
+
+// SetReplay enables the replay of the last published value of a topic
+// to sockets subscribing the topic. The replayed value is received before
+// the values published afterwards. The last values are kept until they are
+// removed with Forget.
+// Only set this during initialization before serving sockets.
+func (p *Publisher) SetReplay(enabled bool) {
+	p.replay = enabled
+}
+
+// SetEventTimeout sets the timeout for subscribers to handle an event.
+// The call timeout of the sockets is used by default. A stalled subscriber
+// delays its own events up to the timeout, but not the events of others.
+// Only set this during initialization before serving sockets.
+func (p *Publisher) SetEventTimeout(t time.Duration) {
+	p.eventTimeout = t
+}
+
+// Serve registers the subscription functions on the socket.
+// Call this before the socket's Ready method.
+func (p *Publisher) Serve(s *pakt.Socket) {
+	s.RegisterFuncs(pakt.Funcs{
+		funcIDSubscribe:   p.handleSubscribe,
+		funcIDUnsubscribe: p.handleUnsubscribe,
+	})
+	s.OnClose(p.removeSocket)
+}
+
+// Publish sends the value to all subscribers of the topic concurrently and
+// waits until all subscribers handled it. Returns a *pakt.BroadcastError
+// holding the error of each subscriber, which failed to receive or handle
+// the event. Each subscriber receives the values of a topic in order. An event
+// is sent to a subscriber as soon as it handled the previous value, so slow
+// subscribers don't delay other subscribers. If replay is enabled, the value
+// is replayed to later subscribers.
+// This method is thread-safe.
+func (p *Publisher) Publish(name string, data interface{}) error {
+	if len(name) == 0 {
+		return ErrInvalidTopic
+	}
+
+	// Raw readers can only be read once.
+	if r, ok := data.(pakt.RawReader); ok {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("pubsub: read raw data: %v", err)
+		}
+		data = pakt.Raw(b)
+	}
+
+	// Queue the events with the topic locked,
+	// so subscriptions either receive the event or the replay.
+	p.topicsMutex.Lock()
+	t := p.getTopic(name)
+	if p.replay {
+		t.last, t.hasLast = data, true
+	}
+	events := make([]event, 0, len(t.queues))
+	for so := range t.queues {
+		events = append(events, t.queue(so))
+	}
+	p.pruneTopic(t)
+	p.topicsMutex.Unlock()
+
+	var (
+		wg       sync.WaitGroup
+		errMutex sync.Mutex
+		errs     map[string]error
+	)
+
+	for _, e := range events {
+		wg.Add(1)
+		go func(e event) {
+			defer wg.Done()
+
+			err := p.send(e, name, data)
+			if err != nil {
+				errMutex.Lock()
+				if errs == nil {
+					errs = make(map[string]error)
+				}
+				errs[e.socket.ID()] = err
+				errMutex.Unlock()
+			}
+		}(e)
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return &pakt.BroadcastError{Errors: errs}
+	}
+	return nil
+}
+
+// Forget removes the last published value of the topic,
+// so it is not replayed to later subscribers.
+// This method is thread-safe.
+func (p *Publisher) Forget(name string) {
+	p.topicsMutex.Lock()
+	defer p.topicsMutex.Unlock()
+
+	if t, ok := p.topics[name]; ok {
+		t.last, t.hasLast = nil, false
+		p.pruneTopic(t)
+	}
+}
+
+// Subscribers returns the sockets subscribed to the topic.
+// This method is thread-safe.
+func (p *Publisher) Subscribers(topic string) []*pakt.Socket {
+	return p.server.GroupSockets(groupPrefix + topic)
+}
+
+//###############//
+//### Private ###//
+//###############//
+
+// topic holds the subscriptions and the last value of a topic.
+// It is guarded by the topics mutex.
+type topic struct {
+	name    string
+	last    interface{}
+	hasLast bool
+
+	// queues maps the subscribed sockets to a channel, which
+	// is closed as soon as their last queued event was handled.
+	queues map[*pakt.Socket]chan struct{}
+}
+
+// event is sent to the socket as soon as the previous event was handled.
+type event struct {
+	socket *pakt.Socket
+	prev   <-chan struct{}
+	done   chan struct{}
+}
+
+// queue appends an event to the queue of the socket.
+func (t *topic) queue(so *pakt.Socket) event {
+	e := event{
+		socket: so,
+		prev:   t.queues[so],
+		done:   make(chan struct{}),
+	}
+	t.queues[so] = e.done
+	return e
+}
+
+// getTopic returns the topic and creates it if required.
+// Must be called with the topics mutex locked.
+func (p *Publisher) getTopic(name string) *topic {
+	t, ok := p.topics[name]
+	if !ok {
+		t = &topic{
+			name:   name,
+			queues: make(map[*pakt.Socket]chan struct{}),
+		}
+		p.topics[name] = t
+	}
+	return t
+}
+
+// pruneTopic removes the topic if unused and no last value is kept.
+// Must be called with the topics mutex locked.
+func (p *Publisher) pruneTopic(t *topic) {
+	if len(t.queues) == 0 && !t.hasLast {
+		delete(p.topics, t.name)
+	}
+}
+
+// send calls the event handler of the socket after the previous event was handled.
+func (p *Publisher) send(e event, name string, data interface{}) error {
+	defer close(e.done)
+	<-e.prev
+
+	args := []interface{}{data}
+	if p.eventTimeout > 0 {
+		args = append(args, p.eventTimeout)
+	}
+
+	_, err := e.socket.Call(funcIDEventPrefix+name, args...)
+	return err
+}
+
+func (p *Publisher) removeSocket(so *pakt.Socket) {
+	p.topicsMutex.Lock()
+	defer p.topicsMutex.Unlock()
+
+	for _, t := range p.topics {
+		delete(t.queues, so)
+		p.pruneTopic(t)
+	}
+}
+
+func (p *Publisher) handleSubscribe(c *pakt.Context) (interface{}, error) {
+	var name string
+	err := c.Decode(&name)
+	if err != nil {
+		return nil, err
+	} else if len(name) == 0 {
+		return nil, ErrInvalidTopic
+	}
+
+	so := c.Socket()
+
+	p.topicsMutex.Lock()
+	t := p.getTopic(name)
+	if _, ok := t.queues[so]; !ok {
+		done := make(chan struct{})
+		close(done)
+		t.queues[so] = done
+	}
+	p.server.AddToGroup(groupPrefix+name, so)
+
+	// Queue the replay of the last value before later values are published.
+	// The subscriber registered the event handler before subscribing.
+	var replay *event
+	if t.hasLast {
+		e := t.queue(so)
+		replay = &e
+	}
+	last := t.last
+	p.topicsMutex.Unlock()
+
+	// Remove the subscription, if the socket closed before it was added.
+	if so.IsClosed() {
+		p.removeSocket(so)
+	}
+
+	// Replay asynchronously, so the subscribe call
+	// does not wait for the subscriber's own handler.
+	if replay != nil {
+		go func() {
+			err := p.send(*replay, name, last)
+			if err != nil && !so.IsClosed() {
+				pakt.Log.Warningf("pubsub: replay: %v", err)
+			}
+		}()
+	}
+
+	return nil, nil
+}
+
+func (p *Publisher) handleUnsubscribe(c *pakt.Context) (interface{}, error) {
+	var name string
+	err := c.Decode(&name)
+	if err != nil {
+		return nil, err
+	} else if len(name) == 0 {
+		return nil, ErrInvalidTopic
+	}
+
+	so := c.Socket()
+
+	p.topicsMutex.Lock()
+	var pending <-chan struct{}
+	if t, ok := p.topics[name]; ok {
+		pending = t.queues[so]
+		delete(t.queues, so)
+		p.pruneTopic(t)
+	}
+	p.server.RemoveFromGroup(groupPrefix+name, so)
+	p.topicsMutex.Unlock()
+
+	// Wait for the queued events, so no event
+	// is received after the subscription was removed.
+	if pending != nil {
+		<-pending
+	}
+	return nil, nil
+}
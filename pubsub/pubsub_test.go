@@ -0,0 +1,330 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package pubsub_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/desertbit/pakt"
+	"github.com/desertbit/pakt/memory"
+	"github.com/desertbit/pakt/pubsub"
+	"github.com/stretchr/testify/require"
+)
+
+func newPublisher(t *testing.T, replay bool) (*pubsub.Publisher, *memory.Listener, func()) {
+	server, ln := memory.NewServer()
+	go server.Listen()
+
+	p := pubsub.NewPublisher(server)
+	p.SetReplay(replay)
+	p.SetEventTimeout(time.Second)
+
+	server.OnNewSocket(func(s *pakt.Socket) {
+		p.Serve(s)
+		s.Ready()
+	})
+
+	return p, ln, server.Close
+}
+
+func newSubscriber(t *testing.T, ln *memory.Listener) (*pubsub.Subscriber, *pakt.Socket) {
+	s, err := memory.NewClient(ln)
+	require.NoError(t, err)
+	s.Ready()
+	return pubsub.NewSubscriber(s), s
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	p, ln, closeServer := newPublisher(t, false)
+	defer closeServer()
+
+	events := make(chan string, 10)
+	handler := func(c *pakt.Context) error {
+		var s string
+		err := c.Decode(&s)
+		if err != nil {
+			return err
+		}
+		events <- s
+		return nil
+	}
+
+	sub1, s1 := newSubscriber(t, ln)
+	defer s1.Close()
+	sub2, s2 := newSubscriber(t, ln)
+	defer s2.Close()
+
+	require.NoError(t, sub1.Subscribe("news", handler))
+	require.NoError(t, sub2.Subscribe("news", handler))
+	require.NoError(t, sub2.Subscribe("sports", handler))
+	require.Equal(t, []string{"news"}, sub1.Topics())
+	require.Len(t, p.Subscribers("news"), 2)
+
+	require.NoError(t, p.Publish("news", "hello"))
+	require.Equal(t, "hello", <-events)
+	require.Equal(t, "hello", <-events)
+
+	require.NoError(t, p.Publish("sports", "goal"))
+	require.Equal(t, "goal", <-events)
+
+	// Topics without subscribers.
+	require.NoError(t, p.Publish("weather", "rain"))
+	require.Equal(t, pubsub.ErrInvalidTopic, p.Publish("", "x"))
+	require.Equal(t, pubsub.ErrInvalidTopic, sub1.Subscribe("", handler))
+
+	// Unsubscribed sockets receive no further events.
+	subscribers := p.Subscribers("news")
+	require.NoError(t, sub1.Unsubscribe("news"))
+	require.Empty(t, sub1.Topics())
+	require.Len(t, p.Subscribers("news"), 1)
+
+	// The handler of the topic is removed.
+	for _, s := range subscribers {
+		if s != p.Subscribers("news")[0] {
+			_, err := s.Call("pakt.pubsub.event/news", "direct", 100*time.Millisecond)
+			require.Equal(t, pakt.ErrTimeout, err)
+		}
+	}
+
+	// Empty topics are rejected.
+	require.Equal(t, pubsub.ErrInvalidTopic, sub1.Unsubscribe(""))
+	_, err := s1.Call("pakt.pubsub.unsubscribe", "")
+	require.Error(t, err)
+
+	require.NoError(t, p.Publish("news", "second"))
+	require.Equal(t, "second", <-events)
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event: %v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Handler errors are reported to the publisher.
+	require.NoError(t, sub2.Subscribe("news", func(c *pakt.Context) error {
+		return errors.New("failed")
+	}))
+	err = p.Publish("news", "third")
+	berr, ok := err.(*pakt.BroadcastError)
+	require.True(t, ok)
+	require.Len(t, berr.Errors, 1)
+
+	// Closed sockets are removed from all topics.
+	require.NoError(t, s2.Close())
+	require.Eventually(t, func() bool {
+		return len(sub2.Topics()) == 0 &&
+			len(p.Subscribers("news")) == 0 &&
+			len(p.Subscribers("sports")) == 0
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestReplay(t *testing.T) {
+	p, ln, closeServer := newPublisher(t, true)
+	defer closeServer()
+
+	require.NoError(t, p.Publish("state", "first"))
+	require.NoError(t, p.Publish("state", "latest"))
+
+	events := make(chan string, 10)
+	sub, s := newSubscriber(t, ln)
+	defer s.Close()
+
+	require.NoError(t, sub.Subscribe("state", func(c *pakt.Context) error {
+		var s string
+		err := c.Decode(&s)
+		events <- s
+		return err
+	}))
+
+	// Late subscribers receive the last value.
+	select {
+	case e := <-events:
+		require.Equal(t, "latest", e)
+	case <-time.After(3 * time.Second):
+		t.Fatal("last value was not replayed")
+	}
+
+	require.NoError(t, p.Publish("state", "next"))
+	require.Equal(t, "next", <-events)
+}
+
+func TestReplayOrder(t *testing.T) {
+	p, ln, closeServer := newPublisher(t, true)
+	defer closeServer()
+
+	const n = 100
+	require.NoError(t, p.Publish("counter", 0))
+
+	events := make(chan int, 2*n)
+	sub, s := newSubscriber(t, ln)
+	defer s.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= n; i++ {
+			require.NoError(t, p.Publish("counter", i))
+		}
+	}()
+
+	require.NoError(t, sub.Subscribe("counter", func(c *pakt.Context) error {
+		var i int
+		err := c.Decode(&i)
+		events <- i
+		return err
+	}))
+	<-done
+
+	// The values are received in order without gaps
+	// after the replayed value.
+	last := <-events
+	for last < n {
+		select {
+		case i := <-events:
+			require.Equal(t, last+1, i)
+			last = i
+		case <-time.After(3 * time.Second):
+			t.Fatalf("missing value after %v", last)
+		}
+	}
+}
+
+func TestForget(t *testing.T) {
+	p, ln, closeServer := newPublisher(t, true)
+	defer closeServer()
+
+	require.NoError(t, p.Publish("state", "old"))
+	p.Forget("state")
+
+	events := make(chan string, 1)
+	sub, s := newSubscriber(t, ln)
+	defer s.Close()
+
+	require.NoError(t, sub.Subscribe("state", func(c *pakt.Context) error {
+		var s string
+		err := c.Decode(&s)
+		events <- s
+		return err
+	}))
+
+	select {
+	case e := <-events:
+		t.Fatalf("forgotten value replayed: %v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestStalledSubscriber(t *testing.T) {
+	p, ln, closeServer := newPublisher(t, true)
+	defer closeServer()
+
+	release := make(chan struct{})
+	stalled := make(chan string, 10)
+	sub1, s1 := newSubscriber(t, ln)
+	defer s1.Close()
+	require.NoError(t, sub1.Subscribe("news", func(c *pakt.Context) error {
+		var s string
+		err := c.Decode(&s)
+		<-release
+		stalled <- s
+		return err
+	}))
+
+	events := make(chan string, 10)
+	handler := func(c *pakt.Context) error {
+		var s string
+		err := c.Decode(&s)
+		events <- s
+		return err
+	}
+	sub2, s2 := newSubscriber(t, ln)
+	defer s2.Close()
+	require.NoError(t, sub2.Subscribe("news", handler))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		require.NoError(t, p.Publish("news", "first"))
+		require.NoError(t, p.Publish("news", "second"))
+	}()
+
+	// Other subscribers receive the first value while the first
+	// subscriber handles it. Subscriptions are not delayed.
+	require.Equal(t, "first", <-events)
+	sub3, s3 := newSubscriber(t, ln)
+	defer s3.Close()
+	require.NoError(t, sub3.Subscribe("news", handler))
+	require.Equal(t, "first", <-events)
+	require.NoError(t, sub3.Unsubscribe("news"))
+
+	// The stalled subscriber receives the values in order.
+	close(release)
+	<-done
+	require.Equal(t, "first", <-stalled)
+	require.Equal(t, "second", <-stalled)
+	require.Equal(t, "second", <-events)
+}
+
+func TestSubscribeFailed(t *testing.T) {
+	server, ln := memory.NewServer()
+	go server.Listen()
+	defer server.Close()
+
+	p := pubsub.NewPublisher(server)
+	sockets := make(chan *pakt.Socket, 1)
+	server.OnNewSocket(func(s *pakt.Socket) {
+		p.Serve(s)
+		s.Ready()
+		sockets <- s
+	})
+
+	sub, s := newSubscriber(t, ln)
+	defer s.Close()
+	ss := <-sockets
+
+	events := make(chan string, 10)
+	require.NoError(t, sub.Subscribe("news", func(c *pakt.Context) error {
+		events <- "first"
+		return nil
+	}))
+
+	// Subscriptions fail if the publisher rejects them.
+	ss.RegisterFunc("pakt.pubsub.subscribe", func(c *pakt.Context) (interface{}, error) {
+		return nil, errors.New("rejected")
+	})
+
+	// The previous handler is restored.
+	require.Error(t, sub.Subscribe("news", func(c *pakt.Context) error {
+		events <- "second"
+		return nil
+	}))
+	require.NoError(t, p.Publish("news", nil))
+	require.Equal(t, "first", <-events)
+	require.Equal(t, []string{"news"}, sub.Topics())
+
+	// The handler of a new topic is removed.
+	require.Error(t, sub.Subscribe("sports", func(c *pakt.Context) error {
+		events <- "sports"
+		return nil
+	}))
+	_, err := ss.Call("pakt.pubsub.event/sports", nil, 100*time.Millisecond)
+	require.Equal(t, pakt.ErrTimeout, err)
+	require.Empty(t, events)
+}
@@ -0,0 +1,136 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package pubsub
+
+import (
+	"sync"
+
+	"github.com/desertbit/pakt"
+)
+
+//#######################//
+//### Subscriber Type ###//
+//#######################//
+
+// HandlerFunc handles the published values of a topic.
+// Returned errors are reported to the publisher.
+type HandlerFunc func(c *pakt.Context) error
+
+// Subscriber subscribes to the topics of the peer's publisher.
+type Subscriber struct {
+	socket *pakt.Socket
+
+	mutex  sync.Mutex
+	topics map[string]HandlerFunc
+}
+
+// NewSubscriber creates a new subscriber for the socket.
+// The subscriptions are removed as soon as the socket closes.
+func NewSubscriber(s *pakt.Socket) *Subscriber {
+	sub := &Subscriber{
+		socket: s,
+		topics: make(map[string]HandlerFunc),
+	}
+
+	s.OnClose(func(*pakt.Socket) {
+		sub.mutex.Lock()
+		sub.topics = make(map[string]HandlerFunc)
+		sub.mutex.Unlock()
+	})
+
+	return sub
+}
+
+// Subscribe to the topic. The handler is called for each published value.
+// Subscribing a topic again replaces the handler.
+// This method is thread-safe.
+func (s *Subscriber) Subscribe(topic string, f HandlerFunc) error {
+	if len(topic) == 0 {
+		return ErrInvalidTopic
+	}
+
+	s.mutex.Lock()
+	prev := s.topics[topic]
+	s.mutex.Unlock()
+
+	// Register the handler before subscribing,
+	// so no event can be missed.
+	s.registerHandler(topic, f)
+
+	_, err := s.socket.Call(funcIDSubscribe, topic)
+	if err != nil {
+		// Restore the previous handler of the subscribed topic.
+		if prev != nil {
+			s.registerHandler(topic, prev)
+		} else {
+			s.socket.UnregisterFunc(funcIDEventPrefix + topic)
+		}
+		return err
+	}
+
+	s.mutex.Lock()
+	s.topics[topic] = f
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// Unsubscribe from the topic.
+// The handler is removed as soon as the publisher removed the subscription.
+// This method is thread-safe.
+func (s *Subscriber) Unsubscribe(topic string) error {
+	if len(topic) == 0 {
+		return ErrInvalidTopic
+	}
+
+	s.mutex.Lock()
+	delete(s.topics, topic)
+	s.mutex.Unlock()
+
+	_, err := s.socket.Call(funcIDUnsubscribe, topic)
+	if err != nil {
+		return err
+	}
+
+	s.socket.UnregisterFunc(funcIDEventPrefix + topic)
+	return nil
+}
+
+// Topics returns the subscribed topics.
+// This method is thread-safe.
+func (s *Subscriber) Topics() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list := make([]string, 0, len(s.topics))
+	for t := range s.topics {
+		list = append(list, t)
+	}
+	return list
+}
+
+//###############//
+//### Private ###//
+//###############//
+
+func (s *Subscriber) registerHandler(topic string, f HandlerFunc) {
+	s.socket.RegisterFunc(funcIDEventPrefix+topic, func(c *pakt.Context) (interface{}, error) {
+		return nil, f(c)
+	})
+}
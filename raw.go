@@ -0,0 +1,90 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package pakt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	featureRaw = "raw"
+)
+
+var (
+	// ErrRawNotSupported defines the error if the peer does not support raw payloads.
+	ErrRawNotSupported = errors.New("raw payloads not supported by peer")
+)
+
+// Raw data is sent verbatim as payload without encoding it with the codec.
+// Pass it as call data or return it from a function.
+// The receiving peer obtains the data with Context.Raw.
+// Plain byte slices are still encoded with the codec, because existing
+// handlers decode them with the codec.
+type Raw []byte
+
+// RawReader reads the data sent verbatim as payload without encoding it with
+// the codec. The reader is read until EOF before the message is sent.
+// The data must not exceed the maximum message size.
+type RawReader struct {
+	io.Reader
+}
+
+//###############//
+//### Private ###//
+//###############//
+
+// rawPayload returns the raw payload of the data and a boolean
+// indicating if the data is raw. The returned pooled buffer is set
+// if the payload was read into a pooled buffer.
+func (s *Socket) rawPayload(dataI interface{}) (payload []byte, pooled *[]byte, raw bool, err error) {
+	switch d := dataI.(type) {
+	case Raw:
+		payload = d
+	case RawReader:
+		w := newBufferWriter(s.maxMessageSize)
+		pooled = w.bp
+		_, err = w.ReadFrom(d.Reader)
+		payload = *w.bp
+		if err != nil && err != ErrMaxMsgSizeExceeded {
+			err = fmt.Errorf("read raw payload: %v", err)
+		}
+	default:
+		return nil, nil, false, nil
+	}
+
+	if err != nil {
+		return nil, pooled, true, err
+	}
+
+	// Raw payloads are only sent to peers supporting them.
+	// The peer's features are known after its hello was received.
+	// Peers of version 0 are identified by their first message.
+	err = s.waitPeerHello()
+	if err == errPeerLegacy {
+		return nil, pooled, true, ErrRawNotSupported
+	} else if err != nil {
+		return nil, pooled, true, err
+	} else if !s.getPeer().Raw {
+		return nil, pooled, true, ErrRawNotSupported
+	}
+
+	return payload, pooled, true, nil
+}
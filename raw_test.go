@@ -0,0 +1,111 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package pakt_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/desertbit/pakt"
+	"github.com/desertbit/pakt/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRawPayload(t *testing.T) {
+	a, b := memory.Pipe()
+	sa := pakt.NewSocket(a)
+	sb := pakt.NewSocket(b)
+	defer sa.Close()
+
+	sa.SetMaxMessageSize(64 * 1024)
+
+	sb.RegisterFunc("upload", func(c *pakt.Context) (interface{}, error) {
+		require.True(t, c.IsRaw())
+
+		data, err := c.Raw()
+		if err != nil {
+			return nil, err
+		}
+
+		// Return the data reversed as reader.
+		r := make([]byte, len(data))
+		for i, v := range data {
+			r[len(data)-1-i] = v
+		}
+		return pakt.RawReader{Reader: bytes.NewReader(r)}, nil
+	})
+	sb.RegisterFunc("encoded", func(c *pakt.Context) (interface{}, error) {
+		require.False(t, c.IsRaw())
+
+		_, err := c.Raw()
+		require.Equal(t, pakt.ErrNoRawData, err)
+
+		var data []byte
+		err = c.Decode(&data)
+		return data, err
+	})
+
+	sa.Ready()
+	sb.Ready()
+
+	data := make([]byte, 32*1024)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	c, err := sa.Call("upload", pakt.Raw(data))
+	require.NoError(t, err)
+	require.True(t, c.IsRaw())
+
+	var ret []byte
+	require.NoError(t, c.Decode(&ret))
+	require.Len(t, ret, len(data))
+	for i := range ret {
+		require.Equal(t, data[len(data)-1-i], ret[i])
+	}
+
+	// Raw data can't be decoded with the codec.
+	var s string
+	require.Error(t, c.Decode(&s))
+
+	// Byte slices are still encoded by default.
+	c, err = sa.Call("encoded", []byte("encoded"))
+	require.NoError(t, err)
+	require.False(t, c.IsRaw())
+	require.NoError(t, c.Decode(&ret))
+	require.Equal(t, []byte("encoded"), ret)
+
+	// Empty raw payloads are flagged as raw.
+	c, err = sa.Call("upload", pakt.Raw(nil))
+	require.NoError(t, err)
+	require.True(t, c.IsRaw())
+	ret, err = c.Raw()
+	require.NoError(t, err)
+	require.Empty(t, ret)
+
+	// The maximum message size is checked while reading.
+	_, err = sa.Call("upload", pakt.RawReader{Reader: bytes.NewReader(make([]byte, 64*1024+1))})
+	require.Equal(t, pakt.ErrMaxMsgSizeExceeded, err)
+
+	c, err = sa.Call("upload", pakt.RawReader{Reader: bytes.NewReader(make([]byte, 64*1024))})
+	require.NoError(t, err)
+	ret, err = c.Raw()
+	require.NoError(t, err)
+	require.Len(t, ret, 64*1024)
+}
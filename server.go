@@ -19,30 +19,71 @@
 package pakt
 
 import (
+	"errors"
+	"fmt"
 	"net"
 	"sync"
+	"time"
 )
 
 const (
-	socketIDLength = 20
-	serverWorkers  = 5
+	socketIDLength   = 20
+	maxSocketIDTries = 10
+
+	handshakeTimeout     = 15 * time.Second
+	maxPendingHandshakes = 128
 
-	newConnChanSize   = 10
 	newSocketChanSize = 10
 )
 
+var (
+	// ErrSocketIDInUse defines the error if a socket ID is already used by another socket.
+	ErrSocketIDInUse = errors.New("socket ID already in use")
+)
+
 //##############//
 //### Server ###//
 //###############//
 
+// IDGenerator generates the ID of a new socket.
+// The connection handshake is completed before, so
+// the TLS connection state of the socket is available.
+type IDGenerator func(s *Socket) (string, error)
+
+// RandomID generates a random socket ID.
+// It is the default ID generator of servers.
+func RandomID(s *Socket) (string, error) {
+	return randomString(socketIDLength)
+}
+
+// IDPolicy defines how servers handle IDs already used by another socket.
+type IDPolicy int
+
+const (
+	// IDPolicyReject rejects IDs used by another socket with ErrSocketIDInUse.
+	// The ID generator is called again for new connections, unless it
+	// returns the same ID twice in a row. It is the default policy.
+	IDPolicyReject IDPolicy = iota
+
+	// IDPolicyReplace closes the socket using the ID, so the ID is
+	// taken over, e.g. by a reconnecting device.
+	IDPolicyReplace
+)
+
 // Server defines the PAKT server implementation.
 type Server struct {
 	ln net.Listener
 
 	sockets      map[string]*Socket
 	socketsMutex sync.RWMutex
+	idGenerator  IDGenerator
+	idPolicy     IDPolicy
 
-	newConnChan   chan net.Conn
+	groupsMutex  sync.RWMutex
+	groups       map[string]map[*Socket]struct{}
+	socketGroups map[*Socket]map[string]struct{}
+
+	handshakeSem  chan struct{}
 	newSocketChan chan *Socket
 
 	closeMutex sync.Mutex
@@ -54,15 +95,14 @@ func NewServer(ln net.Listener) *Server {
 	s := &Server{
 		ln:            ln,
 		sockets:       make(map[string]*Socket),
-		newConnChan:   make(chan net.Conn, newConnChanSize),
+		idGenerator:   RandomID,
+		groups:        make(map[string]map[*Socket]struct{}),
+		socketGroups:  make(map[*Socket]map[string]struct{}),
+		handshakeSem:  make(chan struct{}, maxPendingHandshakes),
 		newSocketChan: make(chan *Socket, newSocketChanSize),
 		closeChan:     make(chan struct{}),
 	}
 
-	for w := 0; w < serverWorkers; w++ {
-		go s.handleConnectionLoop()
-	}
-
 	return s
 }
 
@@ -88,8 +128,16 @@ func (s *Server) Listen() {
 			continue
 		}
 
-		// Pass the new connection to the channel.
-		s.newConnChan <- conn
+		// Handle the new connection in a new goroutine, so slow handshakes
+		// don't block accepting. Limit the number of pending handshakes.
+		select {
+		case s.handshakeSem <- struct{}{}:
+		case <-s.closeChan:
+			conn.Close()
+			return
+		}
+
+		go s.handleConnection(conn)
 	}
 }
 
@@ -175,6 +223,57 @@ func (s *Server) GetSocket(id string) (so *Socket) {
 	return
 }
 
+// SetIDGenerator sets the generator of the socket IDs. IDs must be unique
+// among the connected sockets. The ID policy defines how IDs already in use
+// are handled. New connections are closed if no unique ID is generated.
+// Only set this during initialization before calling Listen.
+func (s *Server) SetIDGenerator(g IDGenerator) {
+	s.idGenerator = g
+}
+
+// SetIDPolicy sets the policy for IDs already used by another socket.
+// It applies to generated IDs and to SetSocketID.
+// Only set this during initialization before calling Listen.
+func (s *Server) SetIDPolicy(p IDPolicy) {
+	s.idPolicy = p
+}
+
+// SetSocketID changes the ID of the connected socket, e.g. as soon as the
+// peer is authenticated. GetSocket returns the socket by its new ID afterwards.
+// Returns ErrSocketIDInUse if the ID is used by another socket and
+// the ID policy is IDPolicyReject.
+// This method is thread-safe.
+func (s *Server) SetSocketID(so *Socket, id string) error {
+	if len(id) == 0 {
+		return errors.New("empty socket ID")
+	}
+
+	s.socketsMutex.Lock()
+
+	oldID := so.ID()
+	if s.sockets[oldID] != so {
+		s.socketsMutex.Unlock()
+		return fmt.Errorf("socket is not connected to the server: %v", oldID)
+	} else if oldID == id {
+		s.socketsMutex.Unlock()
+		return nil
+	}
+
+	replaced, err := s.setSocket(id, so)
+	if err == nil {
+		delete(s.sockets, oldID)
+	}
+	s.socketsMutex.Unlock()
+
+	// Close the replaced socket without the lock held.
+	// It is not removed from the map again, because its ID is taken.
+	if replaced != nil {
+		replaced.Close()
+	}
+
+	return err
+}
+
 // Sockets returns a list of all current connected sockets.
 func (s *Server) Sockets() []*Socket {
 	// Lock the mutex.
@@ -198,16 +297,8 @@ func (s *Server) Sockets() []*Socket {
 //### Private ###//
 //###############//
 
-func (s *Server) handleConnectionLoop() {
-	for {
-		select {
-		case <-s.closeChan:
-			return
-
-		case conn := <-s.newConnChan:
-			s.handleConnection(conn)
-		}
-	}
+type handshaker interface {
+	Handshake() error
 }
 
 func (s *Server) handleConnection(conn net.Conn) {
@@ -218,52 +309,113 @@ func (s *Server) handleConnection(conn net.Conn) {
 		}
 	}()
 
+	socket, err := s.newSocket(conn)
+	if err != nil {
+		Log.Warningf("server: new socket failed: %v", err)
+		conn.Close()
+		return
+	}
+
+	// Remove the socket from the active sockets map on close.
+	// The ID might have changed in the meantime.
+	go func() {
+		// Wait for the socket to close.
+		<-socket.closeChan
+
+		s.socketsMutex.Lock()
+		if id := socket.ID(); s.sockets[id] == socket {
+			delete(s.sockets, id)
+		}
+		s.socketsMutex.Unlock()
+	}()
+
+	// Finally pass the new socket to the channel.
+	select {
+	case s.newSocketChan <- socket:
+	case <-s.closeChan:
+		socket.Close()
+	}
+}
+
+// newSocket completes the connection handshake and adds the new socket
+// to the active sockets map. It releases the pending handshake slot.
+func (s *Server) newSocket(conn net.Conn) (*Socket, error) {
+	defer func() {
+		<-s.handshakeSem
+	}()
+
+	// Complete the connection handshake first if required (e.g. TLS),
+	// so the connection state is available to the new socket.
+	if h, ok := conn.(handshaker); ok {
+		conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+		err := h.Handshake()
+		if err != nil {
+			return nil, fmt.Errorf("handshake: %v", err)
+		}
+
+		conn.SetDeadline(time.Time{})
+	}
+
 	// Create a new socket.
 	socket := NewSocket(conn)
 
 	// Add the new socket to the active sockets map.
-	// If the ID is already present, then generate a new one.
-	err := func() (err error) {
-		socket.id, err = randomString(socketIDLength)
+	err := s.addSocket(socket)
+	if err != nil {
+		return nil, err
+	}
+
+	return socket, nil
+}
+
+// addSocket adds the socket with a generated unique ID to the active sockets map.
+// If the ID is already present, then a new one is generated. Generators returning
+// the same ID again fail immediately.
+func (s *Server) addSocket(socket *Socket) error {
+	var lastID string
+	for i := 0; i < maxSocketIDTries; i++ {
+		id, err := s.idGenerator(socket)
 		if err != nil {
-			return
+			return fmt.Errorf("generate ID: %v", err)
+		} else if len(id) == 0 {
+			return errors.New("generate ID: empty socket ID")
+		} else if id == lastID {
+			return ErrSocketIDInUse
 		}
+		lastID = id
 
-		// Lock the mutex.
 		s.socketsMutex.Lock()
-		defer s.socketsMutex.Unlock()
-
-		// Be sure that the ID is unique.
-		for {
-			if _, ok := s.sockets[socket.id]; !ok {
-				break
-			}
+		replaced, err := s.setSocket(id, socket)
+		s.socketsMutex.Unlock()
 
-			socket.id, err = randomString(socketIDLength)
-			if err != nil {
-				return
-			}
+		if replaced != nil {
+			replaced.Close()
+		}
+		if err != ErrSocketIDInUse {
+			return err
 		}
+	}
 
-		// Add the socket to the map.
-		s.sockets[socket.id] = socket
-		return
-	}()
-	if err != nil {
-		Log.Errorf("server: new socket failed: %v", err)
-		return
+	return ErrSocketIDInUse
+}
+
+// setSocket sets the ID of the socket and adds it to the active sockets map.
+// Returns the socket to close, if the ID policy replaced it.
+// Must be called with the sockets mutex locked.
+func (s *Server) setSocket(id string, so *Socket) (replaced *Socket, err error) {
+	if other, ok := s.sockets[id]; ok {
+		if s.idPolicy != IDPolicyReplace {
+			return nil, ErrSocketIDInUse
+		}
+		replaced = other
 	}
 
-	// Remove the socket from the active sockets map on close.
-	go func() {
-		// Wait for the socket to close.
-		<-socket.closeChan
+	s.sockets[id] = so
 
-		s.socketsMutex.Lock()
-		delete(s.sockets, socket.id)
-		s.socketsMutex.Unlock()
-	}()
+	so.idMutex.Lock()
+	so.id = id
+	so.idMutex.Unlock()
 
-	// Finally pass the new socket to the channel.
-	s.newSocketChan <- socket
+	return replaced, nil
 }
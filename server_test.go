@@ -19,11 +19,16 @@
 package pakt_test
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/desertbit/pakt"
+	"github.com/desertbit/pakt/memory"
 	"github.com/desertbit/pakt/tcp"
 	"github.com/stretchr/testify/require"
 )
@@ -111,3 +116,206 @@ func TestServerSocketsMap(t *testing.T) {
 
 	server.Close()
 }
+
+// chanListener accepts the connections passed to the channel.
+type chanListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (l *chanListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, errors.New("closed")
+	}
+}
+
+func (l *chanListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *chanListener) Addr() net.Addr {
+	return nil
+}
+
+// blockingHandshakeConn blocks the handshake until the connection is closed.
+type blockingHandshakeConn struct {
+	net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *blockingHandshakeConn) Handshake() error {
+	<-c.closed
+	return errors.New("closed")
+}
+
+func (c *blockingHandshakeConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+func TestServerSlowHandshakes(t *testing.T) {
+	ln := &chanListener{conns: make(chan net.Conn), done: make(chan struct{})}
+	server := pakt.NewServer(ln)
+	defer server.Close()
+	go server.Listen()
+
+	// Pending handshakes don't block other connections.
+	for i := 0; i < 10; i++ {
+		a, b := memory.Pipe()
+		defer b.Close()
+		c := &blockingHandshakeConn{Conn: a, closed: make(chan struct{})}
+		defer c.Close()
+		ln.conns <- c
+	}
+
+	a, b := memory.Pipe()
+	cl := pakt.NewSocket(b)
+	defer cl.Close()
+	ln.conns <- a
+
+	select {
+	case s := <-server.NewSocketChan():
+		require.Equal(t, s, server.GetSocket(s.ID()))
+	case <-time.After(3 * time.Second):
+		t.Fatal("socket blocked by pending handshakes")
+	}
+}
+
+func TestServerIDGenerator(t *testing.T) {
+	server, ln := memory.NewServer()
+	defer server.Close()
+
+	// Every second ID collides with the previous one.
+	var n int64
+	server.SetIDGenerator(func(s *pakt.Socket) (string, error) {
+		return fmt.Sprintf("device-%v", atomic.AddInt64(&n, 1)/2), nil
+	})
+
+	sockets := make(chan *pakt.Socket, 3)
+	server.OnNewSocket(func(s *pakt.Socket) {
+		sockets <- s
+	})
+	go server.Listen()
+
+	for i := 0; i < 3; i++ {
+		cl, err := memory.NewClient(ln)
+		require.NoError(t, err)
+		defer cl.Close()
+	}
+
+	ids := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		s := <-sockets
+		require.Equal(t, s, server.GetSocket(s.ID()))
+		ids[s.ID()] = true
+	}
+	require.Len(t, ids, 3)
+
+	// Connections are closed if no unique ID is generated.
+	// Generators returning the same ID again are not retried.
+	var calls int64
+	server.SetIDGenerator(func(s *pakt.Socket) (string, error) {
+		atomic.AddInt64(&calls, 1)
+		return "device-1", nil
+	})
+	cl, err := memory.NewClient(ln)
+	require.NoError(t, err)
+	cl.Ready()
+
+	select {
+	case <-cl.ClosedChan():
+	case <-time.After(3 * time.Second):
+		t.Fatal("socket was not closed")
+	}
+	require.Len(t, server.Sockets(), 3)
+	require.Equal(t, int64(2), atomic.LoadInt64(&calls))
+}
+
+func TestServerIDPolicyReplace(t *testing.T) {
+	server, ln := memory.NewServer()
+	defer server.Close()
+
+	server.SetIDPolicy(pakt.IDPolicyReplace)
+	server.SetIDGenerator(func(s *pakt.Socket) (string, error) {
+		return "device", nil
+	})
+
+	sockets := make(chan *pakt.Socket, 2)
+	server.OnNewSocket(func(s *pakt.Socket) {
+		s.Ready()
+		sockets <- s
+	})
+	go server.Listen()
+
+	// The new socket replaces the socket using the ID.
+	var old *pakt.Socket
+	for i := 0; i < 2; i++ {
+		cl, err := memory.NewClient(ln)
+		require.NoError(t, err)
+		defer cl.Close()
+		cl.Ready()
+
+		s := <-sockets
+		require.Equal(t, "device", s.ID())
+		require.Equal(t, s, server.GetSocket("device"))
+
+		if old != nil {
+			select {
+			case <-old.ClosedChan():
+			case <-time.After(3 * time.Second):
+				t.Fatal("replaced socket was not closed")
+			}
+		}
+		old = s
+	}
+
+	require.Len(t, server.Sockets(), 1)
+	require.Equal(t, old, server.GetSocket("device"))
+}
+
+func TestServerSetSocketID(t *testing.T) {
+	server, ln := memory.NewServer()
+	defer server.Close()
+
+	sockets := make(chan *pakt.Socket, 2)
+	server.OnNewSocket(func(s *pakt.Socket) {
+		s.Ready()
+		sockets <- s
+	})
+	go server.Listen()
+
+	for i := 0; i < 2; i++ {
+		cl, err := memory.NewClient(ln)
+		require.NoError(t, err)
+		defer cl.Close()
+	}
+	s1, s2 := <-sockets, <-sockets
+
+	oldID := s1.ID()
+	require.NoError(t, server.SetSocketID(s1, "user-1"))
+	require.Equal(t, "user-1", s1.ID())
+	require.Equal(t, s1, server.GetSocket("user-1"))
+	require.Nil(t, server.GetSocket(oldID))
+	require.NoError(t, server.SetSocketID(s1, "user-1"))
+
+	require.Equal(t, pakt.ErrSocketIDInUse, server.SetSocketID(s2, "user-1"))
+	require.Error(t, server.SetSocketID(s2, ""))
+
+	other, otherPeer := memory.NewSocketPair()
+	defer other.Close()
+	defer otherPeer.Close()
+	require.Error(t, server.SetSocketID(other, "user-2"))
+
+	// Re-keyed sockets are removed on close.
+	require.NoError(t, s1.Close())
+	require.Eventually(t, func() bool {
+		return server.GetSocket("user-1") == nil
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Len(t, server.Sockets(), 1)
+}
@@ -0,0 +1,105 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package stdio
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// NewConn wraps the reader and writer and returns a net.Conn
+// which can be passed to pakt.NewSocket. Deadlines are applied if the
+// reader or writer support them (e.g. *os.File pipes). Otherwise they are ignored.
+// Closing the connection closes the reader and writer.
+func NewConn(r io.ReadCloser, w io.WriteCloser) net.Conn {
+	return &conn{
+		r: r,
+		w: w,
+	}
+}
+
+//###############//
+//### Private ###//
+//###############//
+
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+type addr struct{}
+
+func (addr) Network() string { return "stdio" }
+func (addr) String() string  { return "stdio" }
+
+type conn struct {
+	r io.ReadCloser
+	w io.WriteCloser
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	return c.w.Write(b)
+}
+
+func (c *conn) Close() error {
+	errW := c.w.Close()
+	errR := c.r.Close()
+	if errW != nil {
+		return errW
+	}
+	return errR
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return addr{}
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return addr{}
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	err := c.SetReadDeadline(t)
+	if err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	if d, ok := c.r.(readDeadliner); ok {
+		return d.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	if d, ok := c.w.(writeDeadliner); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return nil
+}
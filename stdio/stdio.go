@@ -0,0 +1,164 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package stdio provides a PAKT transport over a pair of streams,
+// such as the stdin and stdout of a child process. It can be used
+// to implement plugin processes.
+//
+// The plugin process must not write anything else to its stdout.
+// Log to stderr instead.
+package stdio
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/desertbit/pakt"
+)
+
+const (
+	// DefaultKillTimeout specifies the default duration to wait for a
+	// command to exit after its socket closed, before it is killed.
+	DefaultKillTimeout = 5 * time.Second
+
+	// exitCloseDelay specifies the duration to read the data written by an
+	// exited process, if other processes keep its stdout open.
+	exitCloseDelay = time.Second
+)
+
+// NewSocket returns a new PAKT socket reading from r and writing to w.
+// Both are closed as soon as the socket closes.
+func NewSocket(r io.ReadCloser, w io.WriteCloser) *pakt.Socket {
+	return pakt.NewSocket(NewConn(r, w))
+}
+
+// NewStdioSocket returns a new PAKT socket using the stdin and stdout of
+// the current process. Use this within the plugin process.
+func NewStdioSocket() *pakt.Socket {
+	return NewSocket(os.Stdin, os.Stdout)
+}
+
+// Process defines a started command connected to a PAKT socket.
+type Process struct {
+	// Socket is connected to the stdin and stdout of the process.
+	Socket *pakt.Socket
+
+	// Cmd is the started command.
+	Cmd *exec.Cmd
+
+	exitChan chan struct{}
+	exitErr  error
+}
+
+// StartCommand starts the command and returns the process with a new PAKT
+// socket connected to its stdin and stdout. The command's stdin and stdout
+// must not be set. The process lifecycle is bound to the socket: the socket
+// closes as soon as the process exits, and if the socket closes, the stdin
+// of the process is closed and the process is killed if it does not exit
+// within the timeout. The data written by the process before it exited is
+// read first, unless child processes keep its stdout open for longer than
+// a second.
+// One variadic argument specifies the kill timeout. If not set, DefaultKillTimeout is used.
+func StartCommand(cmd *exec.Cmd, killTimeout ...time.Duration) (*Process, error) {
+	timeout := DefaultKillTimeout
+	if len(killTimeout) > 0 {
+		timeout = killTimeout[0]
+	}
+
+	// Don't use the cmd pipe helpers, because cmd.Wait closes them
+	// and pending data written by the process would be lost.
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return nil, err
+	}
+
+	cmd.Stdin = stdinR
+	cmd.Stdout = stdoutW
+
+	err = cmd.Start()
+
+	// Close the child's ends of the pipes in this process.
+	stdinR.Close()
+	stdoutW.Close()
+
+	if err != nil {
+		stdinW.Close()
+		stdoutR.Close()
+		return nil, err
+	}
+
+	p := &Process{
+		Socket:   NewSocket(stdoutR, stdinW),
+		Cmd:      cmd,
+		exitChan: make(chan struct{}),
+	}
+
+	// Wait for the process to exit and close the socket. Don't rely on the
+	// end of the stdout, because child processes might have inherited it.
+	go func() {
+		p.exitErr = cmd.Wait()
+		close(p.exitChan)
+
+		timer := time.NewTimer(exitCloseDelay)
+		defer timer.Stop()
+
+		select {
+		case <-p.Socket.ClosedChan():
+		case <-timer.C:
+			p.Socket.Close()
+		}
+	}()
+
+	// Terminate the process as soon as the socket closes.
+	p.Socket.OnClose(func(s *pakt.Socket) {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case <-p.exitChan:
+		case <-timer.C:
+			pakt.Log.Warningf("stdio: command did not exit within timeout: killing process")
+			err := cmd.Process.Kill()
+			if err != nil {
+				pakt.Log.Warningf("stdio: kill process: %v", err)
+			}
+		}
+	})
+
+	return p, nil
+}
+
+// ExitedChan returns a channel which is closed as soon as the process exited.
+func (p *Process) ExitedChan() <-chan struct{} {
+	return p.exitChan
+}
+
+// Wait blocks until the process exited and returns the error of cmd.Wait.
+func (p *Process) Wait() error {
+	<-p.exitChan
+	return p.exitErr
+}
@@ -0,0 +1,158 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package stdio_test
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/desertbit/pakt"
+	"github.com/desertbit/pakt/stdio"
+	"github.com/stretchr/testify/require"
+)
+
+const helperEnv = "PAKT_STDIO_HELPER_PROCESS"
+
+// TestHelperProcess is the plugin process started by the tests.
+func TestHelperProcess(t *testing.T) {
+	switch os.Getenv(helperEnv) {
+	case "1":
+	case "sleep":
+		// Keep the inherited stdout open.
+		time.Sleep(10 * time.Second)
+		os.Exit(0)
+	default:
+		return
+	}
+
+	// The parent may close the pipe while the socket is still writing.
+	// Don't exit with a broken pipe signal.
+	signal.Ignore(syscall.SIGPIPE)
+
+	s := stdio.NewStdioSocket()
+	s.RegisterFunc("echo", func(c *pakt.Context) (interface{}, error) {
+		var s string
+		err := c.Decode(&s)
+		return s, err
+	})
+	s.RegisterFunc("leak", func(c *pakt.Context) (interface{}, error) {
+		// Start a child process inheriting the stdout and exit.
+		cmd := exec.Command(os.Args[0], "-test.run=TestHelperProcess")
+		cmd.Env = append(os.Environ(), helperEnv+"=sleep")
+		cmd.Stdout = os.Stdout
+		err := cmd.Start()
+		if err != nil {
+			return nil, err
+		}
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			os.Exit(0)
+		}()
+		return nil, nil
+	})
+	s.RegisterFunc("exit", func(c *pakt.Context) (interface{}, error) {
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			os.Exit(0)
+		}()
+		return nil, nil
+	})
+	s.Ready()
+
+	<-s.ClosedChan()
+	os.Exit(0)
+}
+
+func helperCommand() *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=TestHelperProcess")
+	cmd.Env = append(os.Environ(), helperEnv+"=1")
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+func TestStartCommand(t *testing.T) {
+	p, err := stdio.StartCommand(helperCommand(), time.Second)
+	require.NoError(t, err)
+
+	s := p.Socket
+	s.Ready()
+
+	for i := 0; i < 10; i++ {
+		c, err := s.Call("echo", "hello")
+		require.NoError(t, err)
+
+		var str string
+		require.NoError(t, c.Decode(&str))
+		require.Equal(t, "hello", str)
+	}
+
+	// Closing the socket terminates the process.
+	require.NoError(t, s.Close())
+
+	select {
+	case <-p.ExitedChan():
+	case <-time.After(3 * time.Second):
+		t.Fatal("process did not exit")
+	}
+	require.NoError(t, p.Wait())
+}
+
+func TestCommandExit(t *testing.T) {
+	p, err := stdio.StartCommand(helperCommand())
+	require.NoError(t, err)
+
+	s := p.Socket
+	s.Ready()
+
+	_, err = s.Call("exit")
+	require.NoError(t, err)
+
+	// The socket closes as soon as the process exits.
+	select {
+	case <-s.ClosedChan():
+	case <-time.After(3 * time.Second):
+		t.Fatal("socket was not closed")
+	}
+	require.NoError(t, p.Wait())
+}
+
+func TestCommandExitLeakedStdout(t *testing.T) {
+	p, err := stdio.StartCommand(helperCommand())
+	require.NoError(t, err)
+
+	s := p.Socket
+	s.Ready()
+
+	_, err = s.Call("leak")
+	require.NoError(t, err)
+
+	// The socket closes after the process exited, although
+	// its child process keeps the stdout open.
+	select {
+	case <-s.ClosedChan():
+	case <-time.After(3 * time.Second):
+		t.Fatal("socket was not closed")
+	}
+	require.NoError(t, p.Wait())
+}
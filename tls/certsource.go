@@ -0,0 +1,244 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package tls
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/desertbit/pakt"
+)
+
+// CertLoader defines a function which loads the current certificate.
+type CertLoader func() (*tls.Certificate, error)
+
+// CertSource serves a certificate to new TLS handshakes and reloads it
+// periodically. Reloading does not affect established connections.
+// If a reload fails, the previous certificate is kept.
+type CertSource struct {
+	loader CertLoader
+
+	certMutex sync.RWMutex
+	cert      *tls.Certificate
+
+	closeMutex sync.Mutex
+	closeChan  chan struct{}
+}
+
+// NewCertSource creates a new certificate source which obtains the
+// certificate from the loader. The loader is called once initially
+// and then in the passed reload interval. If the interval is zero or
+// negative, the certificate is only reloaded by calls to Reload.
+// Call Close to stop reloading.
+func NewCertSource(loader CertLoader, reloadInterval time.Duration) (*CertSource, error) {
+	c := &CertSource{
+		loader:    loader,
+		closeChan: make(chan struct{}),
+	}
+
+	err := c.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	if reloadInterval > 0 {
+		go c.reloadLoop(reloadInterval)
+	}
+
+	return c, nil
+}
+
+// NewFileCertSource creates a new certificate source which loads the
+// PEM encoded certificate and key files and reloads them in the passed interval.
+// The files are only parsed again if their modification time or size changed.
+// Replace both files to rotate the certificate.
+func NewFileCertSource(certFile, keyFile string, reloadInterval time.Duration) (*CertSource, error) {
+	l := &fileCertLoader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	return NewCertSource(l.load, reloadInterval)
+}
+
+// Reload the certificate from the loader.
+// The current certificate is kept on error.
+// This method is thread-safe.
+func (c *CertSource) Reload() error {
+	cert, err := c.loader()
+	if err != nil {
+		return err
+	} else if cert == nil {
+		return errors.New("cert source: loader returned no certificate")
+	}
+
+	c.certMutex.Lock()
+	c.cert = cert
+	c.certMutex.Unlock()
+
+	return nil
+}
+
+// Certificate returns the current certificate.
+// This method is thread-safe.
+func (c *CertSource) Certificate() (cert *tls.Certificate) {
+	c.certMutex.RLock()
+	cert = c.cert
+	c.certMutex.RUnlock()
+	return
+}
+
+// GetCertificate can be used as tls.Config.GetCertificate callback.
+func (c *CertSource) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.Certificate(), nil
+}
+
+// GetClientCertificate can be used as tls.Config.GetClientCertificate callback.
+func (c *CertSource) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return c.Certificate(), nil
+}
+
+// ServerConfig returns a copy of the config serving the certificates
+// of this source. The config may be nil.
+func (c *CertSource) ServerConfig(config *tls.Config) *tls.Config {
+	config = cloneConfig(config)
+	config.Certificates = nil
+	config.GetCertificate = c.GetCertificate
+	return config
+}
+
+// ClientConfig returns a copy of the config presenting the certificates
+// of this source as client certificates. The config may be nil.
+func (c *CertSource) ClientConfig(config *tls.Config) *tls.Config {
+	config = cloneConfig(config)
+	config.Certificates = nil
+	config.GetClientCertificate = c.GetClientCertificate
+	return config
+}
+
+// IsClosed returns a boolean indicating if the certificate source is closed.
+func (c *CertSource) IsClosed() bool {
+	select {
+	case <-c.closeChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops reloading the certificate.
+// The last loaded certificate is still served.
+func (c *CertSource) Close() {
+	c.closeMutex.Lock()
+	defer c.closeMutex.Unlock()
+
+	if !c.IsClosed() {
+		close(c.closeChan)
+	}
+}
+
+//###############//
+//### Private ###//
+//###############//
+
+func (c *CertSource) reloadLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closeChan:
+			return
+
+		case <-ticker.C:
+			err := c.Reload()
+			if err != nil {
+				pakt.Log.Warningf("tls: cert source: reload: %v", err)
+			}
+		}
+	}
+}
+
+// fileState identifies the version of a file.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func (s fileState) equal(o fileState) bool {
+	return s.size == o.size && s.modTime.Equal(o.modTime)
+}
+
+func statFile(name string) (fileState, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{modTime: fi.ModTime(), size: fi.Size()}, nil
+}
+
+// fileCertLoader loads the key pair only if the files changed.
+type fileCertLoader struct {
+	certFile string
+	keyFile  string
+
+	mutex     sync.Mutex
+	certState fileState
+	keyState  fileState
+	cert      *tls.Certificate
+}
+
+func (l *fileCertLoader) load() (*tls.Certificate, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// Obtain the states before reading the files. Files
+	// changed while reading are loaded again next time.
+	certState, err := statFile(l.certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyState, err := statFile(l.keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.cert != nil && certState.equal(l.certState) && keyState.equal(l.keyState) {
+		return l.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	l.cert = &cert
+	l.certState = certState
+	l.keyState = keyState
+	return l.cert, nil
+}
+
+func cloneConfig(config *tls.Config) *tls.Config {
+	if config == nil {
+		return &tls.Config{}
+	}
+	return config.Clone()
+}
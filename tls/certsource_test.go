@@ -0,0 +1,113 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package tls_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/desertbit/pakt"
+	paktTLS "github.com/desertbit/pakt/tls"
+	"github.com/desertbit/pakt/tls/tlstest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileCertSource(t *testing.T) {
+	const addr = "127.0.0.1:45361"
+
+	dir, err := ioutil.TempDir("", "pakt-certsource")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	ca, err := tlstest.NewCA()
+	require.NoError(t, err)
+
+	writeCert := func() {
+		certPEM, keyPEM, err := ca.IssuePEM("127.0.0.1")
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(certFile, certPEM, 0600))
+		require.NoError(t, ioutil.WriteFile(keyFile, keyPEM, 0600))
+	}
+
+	writeCert()
+
+	source, err := paktTLS.NewFileCertSource(certFile, keyFile, 50*time.Millisecond)
+	require.NoError(t, err)
+	defer source.Close()
+
+	server, err := paktTLS.NewServer(addr, source.ServerConfig(nil))
+	require.NoError(t, err)
+	defer server.Close()
+
+	server.OnNewSocket(func(s *pakt.Socket) {
+		s.RegisterFunc("ping", func(c *pakt.Context) (interface{}, error) {
+			return nil, nil
+		})
+		s.Ready()
+	})
+
+	go server.Listen()
+
+	connect := func() (*pakt.Socket, *paktTLS.Identity) {
+		config, err := ca.ClientConfig()
+		require.NoError(t, err)
+
+		c, err := paktTLS.NewClient(addr, config)
+		require.NoError(t, err)
+		c.Ready()
+
+		id, err := paktTLS.PeerIdentity(c)
+		require.NoError(t, err)
+		return c, id
+	}
+
+	c1, id1 := connect()
+	defer c1.Close()
+
+	// Unchanged files are not loaded again.
+	cert := source.Certificate()
+	require.NoError(t, source.Reload())
+	require.True(t, cert == source.Certificate())
+
+	// Rotate the certificate and wait for the reload.
+	writeCert()
+	time.Sleep(500 * time.Millisecond)
+
+	c2, id2 := connect()
+	defer c2.Close()
+
+	require.NotEqual(t, id1.Chain[0].SerialNumber, id2.Chain[0].SerialNumber)
+
+	// Established sockets are not affected.
+	_, err = c1.Call("ping")
+	require.NoError(t, err)
+	_, err = c2.Call("ping")
+	require.NoError(t, err)
+
+	// Invalid files keep the current certificate.
+	require.NoError(t, ioutil.WriteFile(certFile, []byte("invalid"), 0600))
+	require.Error(t, source.Reload())
+	require.Equal(t, id2.Chain[0].SerialNumber, source.Certificate().Leaf.SerialNumber)
+}
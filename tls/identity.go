@@ -0,0 +1,154 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package tls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+
+	"github.com/desertbit/pakt"
+)
+
+const (
+	spiffeScheme = "spiffe"
+)
+
+var (
+	// ErrNoTLS defines the error if the socket does not use a TLS connection.
+	ErrNoTLS = errors.New("socket does not use a tls connection")
+
+	// ErrNoPeerCertificate defines the error if the peer did not present a certificate.
+	ErrNoPeerCertificate = errors.New("peer did not present a certificate")
+)
+
+// Identity defines the verified identity of a socket peer
+// derived from its TLS certificate.
+type Identity struct {
+	// ID is the SPIFFE ID if present, otherwise the first DNS name
+	// and otherwise the subject common name of the peer certificate.
+	ID string
+
+	// SPIFFEID is the SPIFFE ID URI SAN of the peer certificate if present.
+	SPIFFEID string
+
+	// DNSNames holds the DNS SANs of the peer certificate.
+	DNSNames []string
+
+	// CommonName is the subject common name of the peer certificate.
+	CommonName string
+
+	// Chain holds the peer certificate chain. The leaf certificate is first.
+	// This is the verified chain if the certificate was verified.
+	Chain []*x509.Certificate
+
+	// Verified is true if the peer certificate chain was verified.
+	Verified bool
+
+	// Version is the negotiated TLS version.
+	Version uint16
+
+	// CipherSuite is the negotiated cipher suite.
+	CipherSuite uint16
+}
+
+// PeerIdentity returns the identity of the peer of a TLS socket.
+// Returns ErrNoTLS if the socket does not use a TLS connection and
+// ErrNoPeerCertificate if the peer did not present a certificate.
+func PeerIdentity(s *pakt.Socket) (*Identity, error) {
+	state, ok := s.TLSConnectionState()
+	if !ok {
+		return nil, ErrNoTLS
+	} else if len(state.PeerCertificates) == 0 {
+		return nil, ErrNoPeerCertificate
+	}
+
+	return newIdentity(state), nil
+}
+
+// VersionName returns the name of the negotiated TLS version.
+func (i *Identity) VersionName() string {
+	return tls.VersionName(i.Version)
+}
+
+// CipherSuiteName returns the name of the negotiated cipher suite.
+func (i *Identity) CipherSuiteName() string {
+	return tls.CipherSuiteName(i.CipherSuite)
+}
+
+// Principal returns a PAKT principal named by the identity ID.
+// It can be used with the socket access policy.
+func (i *Identity) Principal(roles ...string) *pakt.Principal {
+	return &pakt.Principal{
+		Name:  i.ID,
+		Roles: roles,
+	}
+}
+
+//###############//
+//### Private ###//
+//###############//
+
+func newIdentity(state tls.ConnectionState) *Identity {
+	i := &Identity{
+		Chain:       state.PeerCertificates,
+		Version:     state.Version,
+		CipherSuite: state.CipherSuite,
+	}
+
+	if len(state.VerifiedChains) > 0 {
+		i.Chain = state.VerifiedChains[0]
+		i.Verified = true
+	}
+
+	leaf := i.Chain[0]
+	i.DNSNames = leaf.DNSNames
+	i.CommonName = leaf.Subject.CommonName
+
+	for _, u := range leaf.URIs {
+		if u.Scheme == spiffeScheme {
+			i.SPIFFEID = u.String()
+			break
+		}
+	}
+
+	if len(i.SPIFFEID) > 0 {
+		i.ID = i.SPIFFEID
+	} else if len(i.DNSNames) > 0 {
+		i.ID = i.DNSNames[0]
+	} else {
+		i.ID = i.CommonName
+	}
+
+	return i
+}
+
+func (i *Identity) matches(id string) bool {
+	if id == i.SPIFFEID || id == i.CommonName {
+		return len(id) > 0
+	}
+
+	for _, n := range i.DNSNames {
+		if id == n {
+			return true
+		}
+	}
+
+	return false
+}
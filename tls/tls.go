@@ -20,12 +20,15 @@ package tls
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 
 	"github.com/desertbit/pakt"
 )
 
 // NewClient create a new tls client, connects to the remote address
-// and returns a new PAKT socket.
+// and returns a new PAKT socket. The TLS handshake is completed,
+// so the peer identity is available with PeerIdentity.
 func NewClient(remoteAddr string, config *tls.Config) (*pakt.Socket, error) {
 	// Connect to the server.
 	conn, err := tls.Dial("tcp", remoteAddr, config)
@@ -52,3 +55,33 @@ func NewServer(listenAddr string, config *tls.Config) (*pakt.Server, error) {
 
 	return s, nil
 }
+
+// RequireClientCert returns a copy of the server config which requires
+// clients to present a certificate signed by one of the client CAs.
+// Optional allowed IDs restrict the accepted client identities.
+// They are matched against the SPIFFE ID, DNS names and common name
+// of the client certificate. See PeerIdentity.
+func RequireClientCert(config *tls.Config, clientCAs *x509.CertPool, allowedIDs ...string) *tls.Config {
+	c := config.Clone()
+	c.ClientAuth = tls.RequireAndVerifyClientCert
+	c.ClientCAs = clientCAs
+
+	if len(allowedIDs) > 0 {
+		c.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return ErrNoPeerCertificate
+			}
+
+			id := newIdentity(state)
+			for _, a := range allowedIDs {
+				if id.matches(a) {
+					return nil
+				}
+			}
+
+			return fmt.Errorf("client identity not allowed: %v", id.ID)
+		}
+	}
+
+	return c
+}
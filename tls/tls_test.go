@@ -0,0 +1,116 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package tls_test
+
+import (
+	"crypto/tls"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/desertbit/pakt"
+	paktTLS "github.com/desertbit/pakt/tls"
+	"github.com/desertbit/pakt/tls/tlstest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMutualTLS(t *testing.T) {
+	const (
+		addr     = "127.0.0.1:45360"
+		clientID = "spiffe://example.org/client"
+	)
+
+	var wg sync.WaitGroup
+
+	ca, err := tlstest.NewCA()
+	require.NoError(t, err)
+
+	serverConfig, err := ca.ServerConfig("127.0.0.1")
+	require.NoError(t, err)
+	serverConfig = paktTLS.RequireClientCert(serverConfig, ca.CertPool(), clientID)
+
+	server, err := paktTLS.NewServer(addr, serverConfig)
+	require.NoError(t, err)
+	defer server.Close()
+
+	must := func(ok bool, args ...interface{}) {
+		if ok {
+			return
+		}
+
+		wg.Done()
+		t.Fatal(args...)
+	}
+
+	wg.Add(2)
+
+	server.OnNewSocket(func(s *pakt.Socket) {
+		id, err := paktTLS.PeerIdentity(s)
+		must(err == nil, err)
+		must(id.ID == clientID, id.ID)
+		must(id.SPIFFEID == clientID, id.SPIFFEID)
+		must(id.Verified, "verified")
+		must(len(id.Chain) == 2, "chain")
+		must(id.Version >= tls.VersionTLS12, id.VersionName())
+		must(len(id.CipherSuiteName()) > 0, "cipher suite")
+
+		s.SetPrincipal(id.Principal())
+		s.Ready()
+		wg.Done()
+	})
+
+	go server.Listen()
+
+	// Client with a valid certificate.
+	go func() {
+		config, err := ca.ClientConfig(clientID)
+		must(err == nil, err)
+
+		c, err := paktTLS.NewClient(addr, config)
+		must(err == nil, err)
+		defer c.Close()
+
+		id, err := paktTLS.PeerIdentity(c)
+		must(err == nil, err)
+		must(id.ID == "127.0.0.1", id.ID)
+
+		wg.Done()
+	}()
+
+	wg.Wait()
+
+	// Clients with an unknown identity or without certificate are rejected.
+	// The server handler must not be triggered again.
+	for _, names := range [][]string{{"spiffe://example.org/other"}, nil} {
+		config, err := ca.ClientConfig(names...)
+		require.NoError(t, err)
+
+		c, err := paktTLS.NewClient(addr, config)
+		if err != nil {
+			continue
+		}
+		c.Ready()
+
+		select {
+		case <-c.ClosedChan():
+		case <-time.After(5 * time.Second):
+			t.Fatal("rejected client was not closed")
+		}
+	}
+}
@@ -0,0 +1,202 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package tlstest provides a throwaway certificate authority
+// to test TLS and mutual TLS sockets locally.
+package tlstest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	certValidity = 24 * time.Hour
+)
+
+// CA defines a throwaway certificate authority.
+type CA struct {
+	// Cert is the self-signed CA certificate.
+	Cert *x509.Certificate
+
+	key *ecdsa.PrivateKey
+
+	serialMutex sync.Mutex
+	serial      int64
+}
+
+// NewCA creates a new certificate authority with a fresh key.
+func NewCA() (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	ca := &CA{
+		key:    key,
+		serial: 1,
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(ca.serial),
+		Subject:               pkix.Name{CommonName: "pakt test ca"},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(certValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	ca.Cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return ca, nil
+}
+
+// CertPool returns a new pool containing the CA certificate.
+func (ca *CA) CertPool() *x509.CertPool {
+	p := x509.NewCertPool()
+	p.AddCert(ca.Cert)
+	return p
+}
+
+// Issue creates a new certificate signed by the CA, valid for client
+// and server authentication. The names are added as SANs:
+// IP addresses as IP SANs, URIs with a scheme (e.g. spiffe://domain/id)
+// as URI SANs and everything else as DNS names.
+// The first name is also used as subject common name.
+func (ca *CA) Issue(names ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	ca.serialMutex.Lock()
+	ca.serial++
+	serial := ca.serial
+	ca.serialMutex.Unlock()
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(certValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	if len(names) > 0 {
+		tmpl.Subject.CommonName = names[0]
+	}
+
+	for _, n := range names {
+		if ip := net.ParseIP(n); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else if u, err := url.Parse(n); err == nil && len(u.Scheme) > 0 {
+			tmpl.URIs = append(tmpl.URIs, u)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, n)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// IssuePEM creates a new certificate like Issue and
+// returns the PEM encoded certificate and private key.
+func (ca *CA) IssuePEM(names ...string) (certPEM, keyPEM []byte, err error) {
+	cert, err := ca.Issue(names...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// ServerConfig issues a certificate for the names and returns a server
+// config using it. Use the pakt tls.RequireClientCert helper for mutual TLS.
+func (ca *CA) ServerConfig(names ...string) (*tls.Config, error) {
+	cert, err := ca.Issue(names...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ClientConfig issues a client certificate for the names and returns a
+// client config trusting the CA. No client certificate is issued if
+// no names are passed.
+func (ca *CA) ClientConfig(names ...string) (*tls.Config, error) {
+	c := &tls.Config{
+		RootCAs:    ca.CertPool(),
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if len(names) > 0 {
+		cert, err := ca.Issue(names...)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	return c, nil
+}
@@ -0,0 +1,219 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package unix provides a PAKT transport over unix domain sockets
+// for processes running on the same host.
+package unix
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/desertbit/pakt"
+)
+
+const (
+	// DefaultSocketPerm specifies the default file permissions of the server socket file.
+	DefaultSocketPerm os.FileMode = 0660
+
+	staleCheckTimeout = time.Second
+)
+
+var (
+	// ErrNoUnixSocket defines the error if the socket does not use a unix domain socket connection.
+	ErrNoUnixSocket = errors.New("socket does not use a unix domain socket connection")
+)
+
+// NewClient create a new unix domain socket client, connects to the
+// socket file path and returns a new PAKT socket.
+func NewClient(path string) (*pakt.Socket, error) {
+	// Connect to the server.
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a new pakt socket.
+	s := pakt.NewSocket(conn)
+
+	return s, nil
+}
+
+// NewServer create a new unix domain socket server listening on the
+// socket file path and returns a new PAKT server.
+// A stale socket file left behind by a previous process is removed.
+// One variadic argument specifies the file permissions of the
+// socket file. If not set, DefaultSocketPerm is used.
+// The socket file is created with its permissions set, so it is never
+// accessible with the permissions of the umask. Paths close to the maximum
+// socket path length are bound directly and their permissions are set
+// afterwards. The socket file is removed as soon as the server closes.
+func NewServer(path string, perm ...os.FileMode) (*pakt.Server, error) {
+	mode := DefaultSocketPerm
+	if len(perm) > 0 {
+		mode = perm[0]
+	}
+
+	// Remove the socket file if not used anymore.
+	err := removeStaleSocket(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start listening.
+	ln, err := listen(path, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a new pakt server.
+	s := pakt.NewServer(ln)
+
+	return s, nil
+}
+
+// Credentials defines the credentials of the peer process
+// of a unix domain socket.
+type Credentials struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// PeerCredentials returns the credentials of the peer process of the socket.
+// Returns ErrNoUnixSocket if the socket does not use a unix domain socket connection.
+// This is only supported on Linux.
+func PeerCredentials(s *pakt.Socket) (*Credentials, error) {
+	conn, ok := s.Conn().(*net.UnixConn)
+	if !ok {
+		return nil, ErrNoUnixSocket
+	}
+
+	return peerCredentials(conn)
+}
+
+//###############//
+//### Private ###//
+//###############//
+
+// listener removes the socket file on close.
+type listener struct {
+	*net.UnixListener
+	addr *net.UnixAddr
+	once sync.Once
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *listener) Close() error {
+	err := l.UnixListener.Close()
+	l.once.Do(func() {
+		os.Remove(l.addr.Name)
+	})
+	return err
+}
+
+// listen binds the socket within a new private directory, sets the file
+// permissions and links it to the path afterwards. Existing files are
+// never replaced.
+func listen(path string, mode os.FileMode) (net.Listener, error) {
+	dir, err := ioutil.TempDir(filepath.Dir(path), "")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmpPath := filepath.Join(dir, "s")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmpPath, Net: "unix"})
+	if err != nil {
+		// The temporary path might exceed the maximum socket path length,
+		// although the path does not. Bind the path directly instead.
+		if errors.Is(err, syscall.EINVAL) && len(tmpPath) > len(path) {
+			return listenDirect(path, mode)
+		}
+		return nil, err
+	}
+	ln.SetUnlinkOnClose(false)
+
+	err = os.Chmod(tmpPath, mode)
+	if err == nil {
+		err = os.Link(tmpPath, path)
+	}
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	return &listener{
+		UnixListener: ln,
+		addr:         &net.UnixAddr{Name: path, Net: "unix"},
+	}, nil
+}
+
+// listenDirect binds the socket to the path and sets the file permissions
+// afterwards. Until then, the permissions of the umask apply.
+func listenDirect(path string, mode os.FileMode) (net.Listener, error) {
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	ln.SetUnlinkOnClose(false)
+
+	l := &listener{
+		UnixListener: ln,
+		addr:         &net.UnixAddr{Name: path, Net: "unix"},
+	}
+
+	err = os.Chmod(path, mode)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("file exists and is not a socket: %v", path)
+	}
+
+	// Check if another process is still listening.
+	conn, err := net.DialTimeout("unix", path, staleCheckTimeout)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("socket is in use: %v", path)
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,144 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package unix_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/desertbit/pakt"
+	"github.com/desertbit/pakt/unix"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pakt-unix")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "pakt.sock")
+
+	// Leave a stale socket file behind.
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	server, err := unix.NewServer(path, 0600)
+	require.NoError(t, err)
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// No temporary files are left behind.
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	// The socket file is in use.
+	_, err = unix.NewServer(path)
+	require.Error(t, err)
+
+	server.OnNewSocket(func(s *pakt.Socket) {
+		s.RegisterFunc("echo", func(c *pakt.Context) (interface{}, error) {
+			var s string
+			err := c.Decode(&s)
+			return s, err
+		})
+		s.RegisterFunc("cred", func(c *pakt.Context) (interface{}, error) {
+			return unix.PeerCredentials(c.Socket())
+		})
+		s.Ready()
+	})
+
+	go server.Listen()
+
+	c, err := unix.NewClient(path)
+	require.NoError(t, err)
+	c.Ready()
+
+	ctx, err := c.Call("echo", "hello")
+	require.NoError(t, err)
+
+	var s string
+	require.NoError(t, ctx.Decode(&s))
+	require.Equal(t, "hello", s)
+
+	if runtime.GOOS == "linux" {
+		ctx, err = c.Call("cred")
+		require.NoError(t, err)
+
+		var cred unix.Credentials
+		require.NoError(t, ctx.Decode(&cred))
+		require.Equal(t, int32(os.Getpid()), cred.PID)
+		require.Equal(t, uint32(os.Getuid()), cred.UID)
+		require.Equal(t, uint32(os.Getgid()), cred.GID)
+	}
+
+	c.Close()
+	server.Close()
+
+	// The socket file is removed on close.
+	_, err = os.Lstat(path)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestUnixSocketLongPath(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("socket path length limit of linux")
+	}
+
+	dir, err := ioutil.TempDir("", "pakt-unix")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The path fits the limit of 108 bytes,
+	// but a temporary path within it would not.
+	long := filepath.Join(dir, strings.Repeat("d", 104-len(dir)-1))
+	require.NoError(t, os.Mkdir(long, 0700))
+	path := filepath.Join(long, "s")
+	require.Len(t, path, 106)
+
+	server, err := unix.NewServer(path, 0600)
+	require.NoError(t, err)
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	files, err := ioutil.ReadDir(long)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	go server.Listen()
+
+	c, err := unix.NewClient(path)
+	require.NoError(t, err)
+	c.Close()
+
+	server.Close()
+	_, err = os.Lstat(path)
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,141 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package pakt
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	validationErrorPrefix = "validation failed"
+)
+
+//##################//
+//### Validation ###//
+//##################//
+
+// Validator is implemented by values which validate themselves.
+// Context.Decode calls Validate after decoding the value.
+type Validator interface {
+	Validate() error
+}
+
+// ValidateFunc validates a decoded value of a function call.
+type ValidateFunc func(v interface{}) error
+
+// FieldError describes the problem of a single field.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// ValidationError lists the field problems of an invalid value.
+// If returned by a function, also wrapped, the field problems are sent to
+// the caller, which receives the ValidationError from Call.
+// Peers of protocol version 0 receive a plain error.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// Add a field problem.
+func (e *ValidationError) Add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// Err returns the validation error or nil if no field problems were added.
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+	fields := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		if len(f.Field) == 0 {
+			fields[i] = f.Message
+		} else {
+			fields[i] = f.Field + ": " + f.Message
+		}
+	}
+	return validationErrorPrefix + ": " + strings.Join(fields, "; ")
+}
+
+// RegisterValidator registers a validate function for the function ID.
+// It is called by Context.Decode after the value was decoded and validated
+// by its Validator implementation. Pass nil to remove the validator.
+// This method is thread-safe.
+func (s *Socket) RegisterValidator(id string, f ValidateFunc) {
+	s.funcMapMutex.Lock()
+	defer s.funcMapMutex.Unlock()
+
+	if f == nil {
+		delete(s.validators, id)
+		return
+	}
+
+	if s.validators == nil {
+		s.validators = make(map[string]ValidateFunc)
+	}
+	s.validators[id] = f
+}
+
+//###############//
+//### Private ###//
+//###############//
+
+// validate the decoded value of the function call.
+// Other errors than validation errors are converted to validation errors.
+func (s *Socket) validate(funcID string, v interface{}) error {
+	if val, ok := v.(Validator); ok {
+		err := val.Validate()
+		if err != nil {
+			return toValidationError(err)
+		}
+	}
+
+	if len(funcID) == 0 {
+		return nil
+	}
+
+	s.funcMapMutex.RLock()
+	f := s.validators[funcID]
+	s.funcMapMutex.RUnlock()
+
+	if f == nil {
+		return nil
+	}
+
+	err := f(v)
+	if err != nil {
+		return toValidationError(err)
+	}
+	return nil
+}
+
+func toValidationError(err error) *ValidationError {
+	var v *ValidationError
+	if errors.As(err, &v) {
+		return v
+	}
+	return &ValidationError{Fields: []FieldError{{Message: err.Error()}}}
+}
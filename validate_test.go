@@ -0,0 +1,167 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package pakt_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/desertbit/pakt"
+	"github.com/desertbit/pakt/codec/protobuf"
+	"github.com/desertbit/pakt/memory"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type createUser struct {
+	Name string
+	Age  int
+}
+
+func (c *createUser) Validate() error {
+	var v pakt.ValidationError
+	if len(c.Name) == 0 {
+		v.Add("Name", "required")
+	}
+	if c.Age < 0 {
+		v.Add("Age", "must not be negative")
+	}
+	return v.Err()
+}
+
+func TestValidation(t *testing.T) {
+	a, b := memory.Pipe()
+	sa := pakt.NewSocket(a)
+	sb := pakt.NewSocket(b)
+	defer sa.Close()
+
+	handler := func(c *pakt.Context) (interface{}, error) {
+		var u createUser
+		err := c.Decode(&u)
+		if err != nil {
+			return nil, err
+		}
+		return u.Name, nil
+	}
+	sb.RegisterFunc("create", handler)
+	sb.RegisterFunc("createAdult", handler)
+	sb.RegisterValidator("createAdult", func(v interface{}) error {
+		if v.(*createUser).Age < 18 {
+			return errors.New("must be an adult")
+		}
+		return nil
+	})
+
+	sa.Ready()
+	sb.Ready()
+
+	c, err := sa.Call("create", &createUser{Name: "alice", Age: 10})
+	require.NoError(t, err)
+	var name string
+	require.NoError(t, c.Decode(&name))
+	require.Equal(t, "alice", name)
+
+	// The field problems are returned to the caller.
+	_, err = sa.Call("create", &createUser{Age: -1})
+	require.Error(t, err)
+	require.Equal(t, "validation failed: Name: required; Age: must not be negative", err.Error())
+
+	verr, ok := err.(*pakt.ValidationError)
+	require.True(t, ok)
+	require.Equal(t, []pakt.FieldError{
+		{Field: "Name", Message: "required"},
+		{Field: "Age", Message: "must not be negative"},
+	}, verr.Fields)
+
+	// Registered validators run after the Validator implementation.
+	_, err = sa.Call("createAdult", &createUser{Name: "bob", Age: 10})
+	verr, ok = err.(*pakt.ValidationError)
+	require.True(t, ok)
+	require.Equal(t, []pakt.FieldError{{Message: "must be an adult"}}, verr.Fields)
+
+	_, err = sa.Call("createAdult", &createUser{Age: 10})
+	verr, ok = err.(*pakt.ValidationError)
+	require.True(t, ok)
+	require.Equal(t, "Name", verr.Fields[0].Field)
+
+	sb.RegisterValidator("createAdult", nil)
+	_, err = sa.Call("createAdult", &createUser{Name: "bob", Age: 10})
+	require.NoError(t, err)
+
+	// Wrapped validation errors are returned to the caller.
+	sb.RegisterFunc("wrapped", func(c *pakt.Context) (interface{}, error) {
+		var v pakt.ValidationError
+		v.Add("Name", "taken")
+		return nil, fmt.Errorf("create: %w", &v)
+	})
+	_, err = sa.Call("wrapped")
+	verr, ok = err.(*pakt.ValidationError)
+	require.True(t, ok)
+	require.Equal(t, []pakt.FieldError{{Field: "Name", Message: "taken"}}, verr.Fields)
+
+	// Other errors are not converted.
+	sb.RegisterFunc("fail", func(c *pakt.Context) (interface{}, error) {
+		return nil, errors.New("validation failed somehow")
+	})
+	_, err = sa.Call("fail")
+	require.Error(t, err)
+	_, ok = err.(*pakt.ValidationError)
+	require.False(t, ok)
+	require.Equal(t, "validation failed somehow", err.Error())
+}
+
+func TestValidationProtobuf(t *testing.T) {
+	a, b := memory.Pipe()
+	sa := pakt.NewSocket(a)
+	sb := pakt.NewSocket(b)
+	defer sa.Close()
+
+	// The field problems don't depend on the codec.
+	sa.Codec = protobuf.Codec
+	sb.Codec = protobuf.Codec
+
+	sb.RegisterFunc("name", func(c *pakt.Context) (interface{}, error) {
+		var v wrapperspb.StringValue
+		return nil, c.Decode(&v)
+	})
+	sb.RegisterValidator("name", func(v interface{}) error {
+		var verr pakt.ValidationError
+		if len(v.(*wrapperspb.StringValue).GetValue()) < 3 {
+			verr.Add("Value", "too short")
+		}
+		return verr.Err()
+	})
+
+	sa.Ready()
+	sb.Ready()
+
+	_, err := sa.Call("name", wrapperspb.String("ab"))
+	verr, ok := err.(*pakt.ValidationError)
+	require.True(t, ok, err)
+	require.Equal(t, []pakt.FieldError{{Field: "Value", Message: "too short"}}, verr.Fields)
+}
+
+func TestValidationErrorEmpty(t *testing.T) {
+	var v pakt.ValidationError
+	require.NoError(t, v.Err())
+
+	v.Add("", "invalid")
+	require.EqualError(t, v.Err(), "validation failed: invalid")
+}
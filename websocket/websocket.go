@@ -0,0 +1,177 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package websocket provides a PAKT transport over websocket connections
+// for browser clients and deployments behind HTTP proxies.
+package websocket
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/desertbit/pakt"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	acceptChanSize = 10
+)
+
+var (
+	errInvalidMessageType = errors.New("invalid websocket message type: expected binary message")
+	errListenerClosed     = errors.New("websocket listener closed")
+)
+
+// NewClient create a new websocket client, connects to the websocket URL
+// (ws:// or wss://) and returns a new PAKT socket.
+// The optional header is sent with the handshake request.
+func NewClient(url string, header ...http.Header) (*pakt.Socket, error) {
+	return NewClientWithDialer(websocket.DefaultDialer, url, header...)
+}
+
+// NewClientWithDialer create a new websocket client using the dialer,
+// connects to the websocket URL and returns a new PAKT socket.
+// Use a custom dialer to set a TLS config or proxy.
+func NewClientWithDialer(dialer *websocket.Dialer, url string, header ...http.Header) (*pakt.Socket, error) {
+	var h http.Header
+	if len(header) > 0 {
+		h = header[0]
+	}
+
+	// Connect to the server.
+	ws, _, err := dialer.Dial(url, h)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a new pakt socket.
+	s := pakt.NewSocket(NewConn(ws))
+
+	return s, nil
+}
+
+// NewServer creates a new PAKT server and a handler which upgrades
+// HTTP requests to websocket connections and passes them to the server.
+// Register the handler with an HTTP server.
+func NewServer() (*pakt.Server, *Handler) {
+	h := &Handler{
+		ln: newListener(),
+	}
+
+	return pakt.NewServer(h.ln), h
+}
+
+// Handler upgrades HTTP requests to websocket connections
+// and passes them to the PAKT server.
+type Handler struct {
+	// Upgrader is used to upgrade the HTTP requests.
+	// Set CheckOrigin to accept cross-origin browser requests.
+	// Only set this during initialization.
+	Upgrader websocket.Upgrader
+
+	ln *listener
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.ln.IsClosed() {
+		http.Error(w, "server closed", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Upgrade to a websocket connection.
+	// The upgrader replies with an HTTP error on failure.
+	ws, err := h.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		pakt.Log.Warningf("websocket: upgrade: %v", err)
+		return
+	}
+
+	// Pass the connection to the server.
+	conn := NewConn(ws)
+	if !h.ln.pass(conn) {
+		conn.Close()
+	}
+}
+
+//###############//
+//### Private ###//
+//###############//
+
+// listener implements the net.Listener interface for upgraded connections.
+type listener struct {
+	acceptChan chan net.Conn
+
+	closeMutex sync.Mutex
+	closeChan  chan struct{}
+}
+
+func newListener() *listener {
+	return &listener{
+		acceptChan: make(chan net.Conn, acceptChanSize),
+		closeChan:  make(chan struct{}),
+	}
+}
+
+func (l *listener) pass(conn net.Conn) bool {
+	select {
+	case <-l.closeChan:
+		return false
+	case l.acceptChan <- conn:
+		return true
+	}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case <-l.closeChan:
+		return nil, errListenerClosed
+	case conn := <-l.acceptChan:
+		return conn, nil
+	}
+}
+
+func (l *listener) IsClosed() bool {
+	select {
+	case <-l.closeChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *listener) Close() error {
+	l.closeMutex.Lock()
+	defer l.closeMutex.Unlock()
+
+	if !l.IsClosed() {
+		close(l.closeChan)
+	}
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return addr{}
+}
+
+type addr struct{}
+
+func (addr) Network() string { return "websocket" }
+func (addr) String() string  { return "websocket" }
@@ -0,0 +1,101 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package websocket_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/desertbit/pakt"
+	"github.com/desertbit/pakt/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebSocket(t *testing.T) {
+	var wg sync.WaitGroup
+
+	server, handler := websocket.NewServer()
+
+	httpServer := httptest.NewServer(handler)
+	defer httpServer.Close()
+
+	must := func(ok bool, args ...interface{}) {
+		if ok {
+			return
+		}
+
+		wg.Done()
+		t.Fatal(args...)
+	}
+
+	server.OnNewSocket(func(s *pakt.Socket) {
+		s.RegisterFunc("echo", func(c *pakt.Context) (interface{}, error) {
+			var data []byte
+			err := c.Decode(&data)
+			return data, err
+		})
+		s.Ready()
+	})
+
+	go server.Listen()
+
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	// Large payloads span multiple reads.
+	payload := make([]byte, 64*1024)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			c, err := websocket.NewClient(url)
+			must(err == nil, "client:", err)
+			defer c.Close()
+
+			c.SetCallTimeout(5 * time.Second)
+			c.Ready()
+
+			for j := 0; j < 10; j++ {
+				ctx, err := c.Call("echo", payload)
+				must(err == nil, "call:", err)
+
+				var data []byte
+				err = ctx.Decode(&data)
+				must(err == nil, "decode:", err)
+				must(len(data) == len(payload) && data[len(data)-1] == payload[len(payload)-1], "data mismatch")
+			}
+
+			wg.Done()
+		}()
+	}
+
+	wg.Wait()
+
+	server.Close()
+
+	// Closed servers reject new connections.
+	_, err := websocket.NewClient(url)
+	require.Error(t, err)
+}
@@ -0,0 +1,392 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package pakt
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// DefaultSendQueueSize specifies the default number of messages
+	// which may be queued for sending.
+	DefaultSendQueueSize = 512
+
+	// DefaultSendQueueTimeout specifies the default duration to wait
+	// for free space in a full send queue.
+	DefaultSendQueueTimeout = 5 * time.Second
+
+	maxBatchFrames = 64
+	maxBatchSize   = 64 * 1024
+)
+
+var (
+	// ErrSendQueueFull defines the error if a message could not be queued
+	// for sending within the send queue timeout.
+	ErrSendQueueFull = errors.New("send queue full")
+)
+
+// SetSendQueue sets the maximum number of messages queued for sending and
+// the duration to wait for free queue space. Calls and function returns
+// fail with ErrSendQueueFull if the queue stays full.
+// Only set this during initialization before calling Ready.
+func (s *Socket) SetSendQueue(size int, timeout time.Duration) {
+	if size < 1 {
+		size = 1
+	}
+
+	s.sendQueue = make(chan sendFrame, size)
+	s.sendQueueTimeout = timeout
+}
+
+//###############//
+//### Private ###//
+//###############//
+
+type sendFrame struct {
+	version byte
+	reqType byte
+	header  []byte
+	payload []byte
+
+	// pooled is released after the frame was written, if set.
+	pooled *[]byte
+
+	// call is encoded by the writer, if set. The function ID
+	// interning depends on the order of the written frames.
+	call *callHeader
+}
+
+// writerState holds the state of the writer routine.
+type writerState struct {
+	sendQueue        chan sendFrame
+	sendQueueTimeout time.Duration
+	writerStarted    bool // Guarded by the close mutex.
+	writerDone       chan struct{}
+	closingChan      chan struct{}
+
+	// Guarded by the write mutex.
+	writev       bool
+	batchFrames  int
+	batchSize    int
+	batchHeads   [maxBatchFrames * 8]byte
+	batchVec     [maxBatchFrames * 3][]byte
+	batchPooled  []*[]byte
+	batchWriteTo net.Buffers
+}
+
+func newWriterState(writev bool) writerState {
+	return writerState{
+		sendQueue:        make(chan sendFrame, DefaultSendQueueSize),
+		sendQueueTimeout: DefaultSendQueueTimeout,
+		writerDone:       make(chan struct{}),
+		closingChan:      make(chan struct{}),
+		writev:           writev,
+	}
+}
+
+// enqueue the frame for the writer routine.
+// Blocks until the frame is queued, the socket closes or the send queue timeout is reached.
+func (s *Socket) enqueue(f sendFrame) error {
+	if s.IsClosed() {
+		return ErrClosed
+	}
+
+	// Try to queue without a timer first.
+	select {
+	case s.sendQueue <- f:
+		return nil
+	default:
+	}
+
+	timeout := time.NewTimer(s.sendQueueTimeout)
+	defer timeout.Stop()
+
+	select {
+	case s.sendQueue <- f:
+		return nil
+	case <-s.closeChan:
+		return ErrClosed
+	case <-timeout.C:
+		return ErrSendQueueFull
+	}
+}
+
+// writeLoop writes the queued frames. Pending frames are
+// batched into one write. The loop exits after the close
+// message was written or on errors.
+func (s *Socket) writeLoop() {
+	// Close the socket on exit. The writer must be done before.
+	defer s.Close()
+	defer close(s.writerDone)
+
+	// Tell the peer which features are supported. The hello is written
+	// before any queued frame, which might depend on the negotiation.
+	err := s.writeHello()
+	if err != nil {
+		if !s.isClosing() {
+			Log.Warningf("socket: failed to send hello: %v", err)
+		}
+		return
+	}
+
+	for {
+		var f sendFrame
+		select {
+		case <-s.closeChan:
+			return
+		case f = <-s.sendQueue:
+		}
+
+		// Lock the mutex. The batch is guarded by it.
+		s.writeMutex.Lock()
+
+		err := s.appendSendFrame(&f)
+		last := f.reqType == typeClose
+
+		// Batch the pending frames.
+	Loop:
+		for err == nil && !last && s.batchFrames < maxBatchFrames && s.batchSize < maxBatchSize {
+			select {
+			case f = <-s.sendQueue:
+				err = s.appendSendFrame(&f)
+				last = f.reqType == typeClose
+			default:
+				break Loop
+			}
+		}
+
+		if err == nil {
+			err = s.flushFrames()
+		} else {
+			s.resetBatch()
+		}
+
+		s.writeMutex.Unlock()
+
+		if err != nil {
+			// Don't log errors if the socket is closing.
+			// The connection might be closed by the peer.
+			if err != ErrClosed && !s.isClosing() {
+				Log.Warningf("socket: write: %v", err)
+			}
+			return
+		} else if last {
+			return
+		}
+	}
+}
+
+// isClosing returns a boolean indicating if the socket is closing or closed.
+func (s *Socket) isClosing() bool {
+	select {
+	case <-s.closingChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeWriter writes the pending frames followed by the close message
+// and waits for the writer routine to exit.
+// Must be called with the close mutex locked.
+func (s *Socket) closeWriter() {
+	close(s.closingChan)
+
+	if !s.writerStarted {
+		// Ignore errors. The connection might be closed already.
+		_ = s.writeFrame(s.sendVersion(), typeClose, nil, nil)
+		return
+	}
+
+	timeout := time.NewTimer(writeTimeout)
+	defer timeout.Stop()
+
+	select {
+	case s.sendQueue <- sendFrame{version: s.sendVersion(), reqType: typeClose}:
+	case <-s.writerDone:
+		return
+	case <-timeout.C:
+		return
+	}
+
+	select {
+	case <-s.writerDone:
+	case <-timeout.C:
+	}
+}
+
+// writeFrame writes one frame directly to the connection.
+func (s *Socket) writeFrame(version, reqType byte, header, payload []byte) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	err := s.appendFrame(version, reqType, header, payload)
+	if err != nil {
+		s.resetBatch()
+		return err
+	}
+
+	return s.flushFrames()
+}
+
+// appendSendFrame adds the queued frame to the batch.
+// Must be called with the write mutex locked.
+func (s *Socket) appendSendFrame(f *sendFrame) error {
+	if f.pooled != nil {
+		s.batchPooled = append(s.batchPooled, f.pooled)
+	}
+
+	header := f.header
+	if f.call != nil {
+		var err error
+		header, err = s.encodeInternedCallHeader(f.version, f.call)
+		if err != nil {
+			return fmt.Errorf("encode header: %v", err)
+		}
+	}
+
+	return s.appendFrame(f.version, f.reqType, header, f.payload)
+}
+
+// appendFrame adds the frame to the batch.
+// Must be called with the write mutex locked.
+func (s *Socket) appendFrame(version, reqType byte, header, payload []byte) (err error) {
+	// Check if the maximum header size is exceeded.
+	if len(header) > maxHeaderBufferSize {
+		return fmt.Errorf("maximum header size exceeded")
+	} else if uint64(len(payload)) > uint32Max-gcmOverhead {
+		return ErrMaxMsgSizeExceeded
+	}
+
+	// Encrypted messages are sent after the peer's nonce is known.
+	// No encrypted frame can be batched before.
+	encrypt := s.keyring != nil && reqType != typeHello
+	if encrypt {
+		err = s.waitPeerHello()
+		if err != nil {
+			return err
+		}
+
+		// The hello MAC is the first encrypted frame. No frame is batched
+		// before, so the batch has space for both frames.
+		if !s.helloMACSent {
+			err = s.appendHelloMAC()
+			if err != nil {
+				return err
+			}
+		}
+		reqType |= flagEncrypted
+	}
+
+	headerSize, payloadSize := len(header), len(payload)
+	if encrypt {
+		headerSize, payloadSize = encryptedSizes(headerSize, payloadSize)
+	}
+
+	// Fill the message head.
+	head := s.batchHeads[s.batchFrames*8 : (s.batchFrames+1)*8]
+	head[0] = version
+	head[1] = reqType
+	endian.PutUint16(head[2:4], uint16(headerSize))
+	endian.PutUint32(head[4:8], uint32(payloadSize))
+
+	// Encrypted messages must be written in the order of their sequence numbers.
+	if encrypt {
+		encHeader, encPayload, err := s.encryptFrame(head, header, payload)
+		if err != nil {
+			return fmt.Errorf("encrypt: %v", err)
+		}
+
+		header = *encHeader
+		s.batchPooled = append(s.batchPooled, encHeader)
+
+		payload = nil
+		if encPayload != nil {
+			payload = *encPayload
+			s.batchPooled = append(s.batchPooled, encPayload)
+		}
+	}
+
+	i := s.batchFrames * 3
+	s.batchVec[i] = head
+	s.batchVec[i+1] = header
+	s.batchVec[i+2] = payload
+
+	s.batchFrames++
+	s.batchSize += len(head) + len(header) + len(payload)
+
+	return nil
+}
+
+// flushFrames writes the batched frames to the connection.
+// Must be called with the write mutex locked.
+func (s *Socket) flushFrames() (err error) {
+	defer s.resetBatch()
+
+	if s.batchFrames == 0 {
+		return nil
+	}
+
+	// Reset the write deadline.
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+	vec := s.batchVec[:s.batchFrames*3]
+
+	if s.writev {
+		// Write all frames with one system call.
+		// WriteTo consumes the slice, so use a separate slice header.
+		s.batchWriteTo = vec
+		_, err = s.batchWriteTo.WriteTo(s.conn)
+		return err
+	}
+
+	// Copy the frames into one buffer to avoid partial writes.
+	bp := getBuffer(s.batchSize)
+	defer putBuffer(bp)
+
+	buf := *bp
+	n := 0
+	for _, b := range vec {
+		n += copy(buf[n:], b)
+	}
+
+	_, err = s.conn.Write(buf)
+	return err
+}
+
+// resetBatch releases the batch buffers.
+// Must be called with the write mutex locked.
+func (s *Socket) resetBatch() {
+	// Don't keep references to the written data.
+	for i := 0; i < s.batchFrames*3; i++ {
+		s.batchVec[i] = nil
+	}
+
+	for i, bp := range s.batchPooled {
+		putBuffer(bp)
+		s.batchPooled[i] = nil
+	}
+
+	s.batchPooled = s.batchPooled[:0]
+	s.batchWriteTo = nil
+	s.batchFrames = 0
+	s.batchSize = 0
+}
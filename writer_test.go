@@ -0,0 +1,138 @@
+/*
+ *  PAKT - Interlink Remote Applications
+ *  Copyright (C) 2016  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package pakt
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordConn records the written bytes and blocks reads until closed.
+type recordConn struct {
+	net.Conn
+
+	mutex   sync.Mutex
+	writes  int
+	written bytes.Buffer
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+func newRecordConn() *recordConn {
+	return &recordConn{closeChan: make(chan struct{})}
+}
+
+func (c *recordConn) Read(b []byte) (int, error) {
+	<-c.closeChan
+	return 0, io.EOF
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.writes++
+	return c.written.Write(b)
+}
+
+func (c *recordConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closeChan) })
+	return nil
+}
+
+func (c *recordConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *recordConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// frameTypes returns the types of the written frames.
+func (c *recordConn) frameTypes(t *testing.T) (types []byte, writes int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	b := c.written.Bytes()
+	for len(b) > 0 {
+		require.True(t, len(b) >= 8)
+		l := 8 + int(endian.Uint16(b[2:4])) + int(endian.Uint32(b[4:8]))
+		require.True(t, len(b) >= l)
+
+		types = append(types, b[1]&typeMask)
+		b = b[l:]
+	}
+	return types, c.writes
+}
+
+func TestWriterBatching(t *testing.T) {
+	conn := newRecordConn()
+	s := NewSocket(conn)
+
+	// Queue messages before the writer routine is started.
+	for i := 0; i < 10; i++ {
+		require.NoError(t, s.write(0, typePing, nil, nil))
+	}
+
+	s.Ready()
+	require.NoError(t, s.Close())
+
+	types, writes := conn.frameTypes(t)
+	require.Len(t, types, 12)
+	require.Equal(t, typeHello, types[0])
+	require.Equal(t, typeClose, types[11])
+	for _, typ := range types[1:11] {
+		require.Equal(t, typePing, typ)
+	}
+
+	// The hello is written directly. The queued messages are batched.
+	require.True(t, writes <= 3, writes)
+
+	require.Equal(t, ErrClosed, s.write(0, typePing, nil, nil))
+}
+
+func TestWriterCloseBeforeReady(t *testing.T) {
+	conn := newRecordConn()
+	s := NewSocket(conn)
+	require.NoError(t, s.Close())
+
+	types, _ := conn.frameTypes(t)
+	require.Equal(t, []byte{typeClose}, types)
+}
+
+func TestSendQueueFull(t *testing.T) {
+	conn := newRecordConn()
+	s := NewSocket(conn)
+	s.SetSendQueue(2, 50*time.Millisecond)
+
+	// The writer routine is not started, so the queue is not drained.
+	require.NoError(t, s.write(0, typePing, nil, nil))
+	require.NoError(t, s.write(0, typePing, nil, nil))
+	require.Equal(t, ErrSendQueueFull, s.write(0, typePing, nil, nil))
+
+	_, err := s.Call("func", nil, time.Second)
+	require.Equal(t, ErrSendQueueFull, err)
+
+	s.Ready()
+	require.NoError(t, s.Close())
+
+	types, _ := conn.frameTypes(t)
+	require.Equal(t, []byte{typeHello, typePing, typePing, typeClose}, types)
+}